@@ -20,6 +20,9 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "  -arch <arch>      Target architecture: x86, x86_64, arm, arm64 (default: x86_64)\n")
 	fmt.Fprintf(os.Stderr, "  -format <format>  Output format: elf, pe (default: elf)\n")
 	fmt.Fprintf(os.Stderr, "  -o <file>         Output file\n")
+	fmt.Fprintf(os.Stderr, "  -O0, -Ox          Disable/enable shortest-form immediate encoding (default: -Ox)\n")
+	fmt.Fprintf(os.Stderr, "  -disable-features <list>\n")
+	fmt.Fprintf(os.Stderr, "                    Reject instructions needing these CPU features, e.g. BMI2,AVX512F\n")
 	os.Exit(2)
 }
 
@@ -31,6 +34,8 @@ func main() {
 	var inputFile, outputFile string
 	var targetArch = arch.ArchX86_64
 	var targetFormat = format.FormatELF
+	var disabledFeatures []string
+	optimize := true
 
 	i := 1
 	for i < len(os.Args) {
@@ -59,6 +64,19 @@ func main() {
 					os.Exit(1)
 				}
 				i += 2
+			case "-O0":
+				optimize = false
+				i++
+			case "-Ox":
+				optimize = true
+				i++
+			case "-disable-features":
+				if i+1 >= len(os.Args) {
+					fmt.Fprintf(os.Stderr, "Error: -disable-features requires argument\n")
+					os.Exit(1)
+				}
+				disabledFeatures = append(disabledFeatures, strings.Split(os.Args[i+1], ",")...)
+				i += 2
 			case "-o":
 				if i+1 >= len(os.Args) {
 					fmt.Fprintf(os.Stderr, "Error: -o requires argument\n")
@@ -112,8 +130,19 @@ func main() {
 
 	var encoder arch.Encoder
 	switch targetArch {
-	case arch.ArchX86_64:
-		encoder = x86_64.NewEncoder()
+	case arch.ArchX86, arch.ArchX86_64:
+		enc := x86_64.NewEncoder()
+		if targetArch == arch.ArchX86 {
+			enc = x86_64.NewX86Encoder()
+		}
+		if err := enc.DisableFeatures(disabledFeatures...); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !optimize {
+			enc.DisableOptimization()
+		}
+		encoder = enc
 	default:
 		fmt.Fprintf(os.Stderr, "Error: unsupported architecture: %s\n", targetArch)
 		os.Exit(1)
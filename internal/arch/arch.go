@@ -68,6 +68,7 @@ const (
 	RelocRel64
 	RelocCall
 	RelocBranch
+	RelocAbs16
 )
 
 type Section struct {
@@ -81,15 +82,32 @@ type AssemblyResult struct {
 	Relocs   []Reloc
 }
 
+type Context struct {
+	PC         uint64
+	Section    string
+	MinSize    int
+	DefaultRel bool
+	Bits       int
+	Lookup     func(name string) (Symbol, bool)
+}
+
+type Fixup struct {
+	Offset int
+	Size   int
+	Kind   RelocKind
+	Symbol string
+	Addend int64
+}
+
 type Encoder interface {
 	Arch() Arch
 	WordSize() int
-	EncodeInstruction(ins *ast.Instruction) ([]byte, error)
+	EncodeInstruction(ins *ast.Instruction, ctx *Context) ([]byte, []Fixup, error)
 	Registers() map[string]int
 	IsRegister(name string) bool
 }
 
-type EncoderFunc func(ins *ast.Instruction) ([]byte, error)
+type EncoderFunc func(ins *ast.Instruction, ctx *Context) ([]byte, []Fixup, error)
 
 type BaseEncoder struct {
 	arch      Arch
@@ -0,0 +1,64 @@
+package x86_64
+
+import "fmt"
+
+// bitTable generates the bit test and scan instructions at 16, 32 and 64
+// bits. The bit tests take the bit index from a register or an imm8;
+// bts, btr and btc modify their destination and accept lock.
+func bitTable() []tableEntry {
+	var out []tableEntry
+	for _, w := range []struct{ size, prefix string }{{"16", "o16 "}, {"32", ""}, {"64", "REX.W "}} {
+		r, rm := "r"+w.size, "r/m"+w.size
+		for _, bt := range []struct {
+			mnemonic string
+			opcode   byte
+			digit    int
+		}{
+			{"bt", 0xA3, 4}, {"bts", 0xAB, 5}, {"btr", 0xB3, 6}, {"btc", 0xBB, 7},
+		} {
+			lock := "lock "
+			if bt.mnemonic == "bt" {
+				lock = ""
+			}
+			out = append(out,
+				tableEntry{bt.mnemonic, rm + ", " + r, fmt.Sprintf("%s%s0F %02X /r", lock, w.prefix, bt.opcode), ""},
+				tableEntry{bt.mnemonic, rm + ", imm8", fmt.Sprintf("%s%s0F BA /%d ib", lock, w.prefix, bt.digit), ""},
+			)
+		}
+		out = append(out,
+			tableEntry{"bsf", r + ", " + rm, w.prefix + "0F BC /r", ""},
+			tableEntry{"bsr", r + ", " + rm, w.prefix + "0F BD /r", ""},
+			tableEntry{"popcnt", r + ", " + rm, w.prefix + "F3 0F B8 /r", "POPCNT"},
+			tableEntry{"lzcnt", r + ", " + rm, w.prefix + "F3 0F BD /r", "LZCNT"},
+			tableEntry{"tzcnt", r + ", " + rm, w.prefix + "F3 0F BC /r", "BMI1"},
+		)
+	}
+	return out
+}
+
+// bmiTable generates the VEX-encoded BMI1 and BMI2 instructions on 32-
+// and 64-bit registers; VEX.W selects the operand size. RMV forms take
+// their last operand from VEX.vvvv.
+func bmiTable() []tableEntry {
+	var out []tableEntry
+	for _, w := range []struct{ size, w string }{{"32", "W0"}, {"64", "W1"}} {
+		r, rm := "r"+w.size, "r/m"+w.size
+		vex := func(form, pp, m string) string { return fmt.Sprintf("VEX.%sLZ.%s%s.%s", form, pp, m, w.w) }
+		out = append(out,
+			tableEntry{"andn", r + ", " + r + ", " + rm, vex("NDS.", "", "0F38") + " F2 /r", "BMI1"},
+			tableEntry{"bextr", r + ", " + rm + ", " + r, "RMV " + vex("", "", "0F38") + " F7 /r", "BMI1"},
+			tableEntry{"blsi", r + ", " + rm, vex("NDD.", "", "0F38") + " F3 /3", "BMI1"},
+			tableEntry{"blsmsk", r + ", " + rm, vex("NDD.", "", "0F38") + " F3 /2", "BMI1"},
+			tableEntry{"blsr", r + ", " + rm, vex("NDD.", "", "0F38") + " F3 /1", "BMI1"},
+			tableEntry{"bzhi", r + ", " + rm + ", " + r, "RMV " + vex("", "", "0F38") + " F5 /r", "BMI2"},
+			tableEntry{"pdep", r + ", " + r + ", " + rm, vex("NDS.", "F2.", "0F38") + " F5 /r", "BMI2"},
+			tableEntry{"pext", r + ", " + r + ", " + rm, vex("NDS.", "F3.", "0F38") + " F5 /r", "BMI2"},
+			tableEntry{"mulx", r + ", " + r + ", " + rm, vex("NDS.", "F2.", "0F38") + " F6 /r", "BMI2"},
+			tableEntry{"rorx", r + ", " + rm + ", imm8", vex("", "F2.", "0F3A") + " F0 /r ib", "BMI2"},
+			tableEntry{"sarx", r + ", " + rm + ", " + r, "RMV " + vex("", "F3.", "0F38") + " F7 /r", "BMI2"},
+			tableEntry{"shlx", r + ", " + rm + ", " + r, "RMV " + vex("", "66.", "0F38") + " F7 /r", "BMI2"},
+			tableEntry{"shrx", r + ", " + rm + ", " + r, "RMV " + vex("", "F2.", "0F38") + " F7 /r", "BMI2"},
+		)
+	}
+	return out
+}
@@ -0,0 +1,43 @@
+package x86_64
+
+import "fmt"
+
+var cryptoTable = []tableEntry{
+	{"aesimc", "xmm, xmm/m128", "66 0F 38 DB /r", "AES"},
+	{"aeskeygenassist", "xmm, xmm/m128, imm8", "66 0F 3A DF /r ib", "AES"},
+	{"vaesimc", "xmm, xmm/m128", "VEX.128.66.0F38.WIG DB /r", "AES,AVX"},
+	{"vaeskeygenassist", "xmm, xmm/m128, imm8", "VEX.128.66.0F3A.WIG DF /r ib", "AES,AVX"},
+
+	{"pclmulqdq", "xmm, xmm/m128, imm8", "66 0F 3A 44 /r ib", "PCLMULQDQ"},
+	{"vpclmulqdq", "xmm, xmm, xmm/m128, imm8", "VEX.NDS.128.66.0F3A.WIG 44 /r ib", "PCLMULQDQ,AVX"},
+	{"vpclmulqdq", "ymm, ymm, ymm/m256, imm8", "VEX.NDS.256.66.0F3A.WIG 44 /r ib", "VPCLMULQDQ,AVX"},
+	{"vpclmulqdq", "zmm, zmm, zmm/m512, imm8", "EVEX.NDS.512.66.0F3A.WIG 44 /r ib", "VPCLMULQDQ,AVX512F"},
+
+	// sha256rnds2 reads xmm0 implicitly; NASM lets it be written out.
+	{"sha1rnds4", "xmm, xmm/m128, imm8", "0F 3A CC /r ib", "SHA"},
+	{"sha1nexte", "xmm, xmm/m128", "0F 38 C8 /r", "SHA"},
+	{"sha1msg1", "xmm, xmm/m128", "0F 38 C9 /r", "SHA"},
+	{"sha1msg2", "xmm, xmm/m128", "0F 38 CA /r", "SHA"},
+	{"sha256rnds2", "xmm, xmm/m128", "0F 38 CB /r", "SHA"},
+	{"sha256rnds2", "xmm, xmm/m128, xmm0", "0F 38 CB /r", "SHA"},
+	{"sha256msg1", "xmm, xmm/m128", "0F 38 CC /r", "SHA"},
+	{"sha256msg2", "xmm, xmm/m128", "0F 38 CD /r", "SHA"},
+}
+
+// aesTable generates the AES round instructions: the legacy SSE form,
+// the VEX form at 128 bits and the wider VAES forms.
+func aesTable() []tableEntry {
+	var out []tableEntry
+	for _, op := range []struct {
+		name   string
+		opcode byte
+	}{{"aesenc", 0xDC}, {"aesenclast", 0xDD}, {"aesdec", 0xDE}, {"aesdeclast", 0xDF}} {
+		out = append(out,
+			tableEntry{op.name, "xmm, xmm/m128", fmt.Sprintf("66 0F 38 %02X /r", op.opcode), "AES"},
+			tableEntry{"v" + op.name, "xmm, xmm, xmm/m128", fmt.Sprintf("VEX.NDS.128.66.0F38.WIG %02X /r", op.opcode), "AES,AVX"},
+			tableEntry{"v" + op.name, "ymm, ymm, ymm/m256", fmt.Sprintf("VEX.NDS.256.66.0F38.WIG %02X /r", op.opcode), "VAES,AVX"},
+			tableEntry{"v" + op.name, "zmm, zmm, zmm/m512", fmt.Sprintf("EVEX.NDS.512.66.0F38.WIG %02X /r", op.opcode), "VAES,AVX512F"},
+		)
+	}
+	return out
+}
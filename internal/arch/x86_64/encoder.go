@@ -2,448 +2,724 @@ package x86_64
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"gasm/internal/arch"
 	"gasm/internal/ast"
+	"slices"
 	"strings"
 )
 
 type Encoder struct {
 	*arch.BaseEncoder
+	disabled map[string]bool
+	optimize bool
 }
 
 func NewEncoder() *Encoder {
-	regs := map[string]int{
-		"rax": 0, "eax": 0, "ax": 0, "al": 0, "ah": 0,
-		"rcx": 1, "ecx": 1, "cx": 1, "cl": 1, "ch": 1,
-		"rdx": 2, "edx": 2, "dx": 2, "dl": 2, "dh": 2,
-		"rbx": 3, "ebx": 3, "bx": 3, "bl": 3, "bh": 3,
-		"rsp": 4, "esp": 4, "sp": 4, "spl": 4,
-		"rbp": 5, "ebp": 5, "bp": 5, "bpl": 5,
-		"rsi": 6, "esi": 6, "si": 6, "sil": 6,
-		"rdi": 7, "edi": 7, "di": 7, "dil": 7,
-		"r8": 8, "r8d": 8, "r8w": 8, "r8b": 8,
-		"r9": 9, "r9d": 9, "r9w": 9, "r9b": 9,
-		"r10": 10, "r10d": 10, "r10w": 10, "r10b": 10,
-		"r11": 11, "r11d": 11, "r11w": 11, "r11b": 11,
-		"r12": 12, "r12d": 12, "r12w": 12, "r12b": 12,
-		"r13": 13, "r13d": 13, "r13w": 13, "r13b": 13,
-		"r14": 14, "r14d": 14, "r14w": 14, "r14b": 14,
-		"r15": 15, "r15d": 15, "r15w": 15, "r15b": 15,
-	}
-	return &Encoder{BaseEncoder: arch.NewBaseEncoder(arch.ArchX86_64, 8, regs)}
-}
-
-func (e *Encoder) EncodeInstruction(ins *ast.Instruction) ([]byte, error) {
-	var buf bytes.Buffer
-	mn := strings.ToLower(ins.Mnemonic)
-
-	switch mn {
-	case "mov":
-		return e.encodeMov(&buf, ins)
-	case "xor":
-		return e.encodeXor(&buf, ins)
-	case "add":
-		return e.encodeAdd(&buf, ins)
-	case "sub":
-		return e.encodeSub(&buf, ins)
-	case "inc":
-		return e.encodeInc(&buf, ins)
-	case "dec":
-		return e.encodeDec(&buf, ins)
-	case "cmp":
-		return e.encodeCmp(&buf, ins)
-	case "jmp":
-		return e.encodeJmp(&buf, ins)
-	case "je", "jz":
-		return e.encodeJcc(&buf, ins, 0x84)
-	case "jne", "jnz":
-		return e.encodeJcc(&buf, ins, 0x85)
-	case "jg":
-		return e.encodeJcc(&buf, ins, 0x8F)
-	case "jl":
-		return e.encodeJcc(&buf, ins, 0x8C)
-	case "jge":
-		return e.encodeJcc(&buf, ins, 0x8D)
-	case "jle":
-		return e.encodeJcc(&buf, ins, 0x8E)
-	case "ja":
-		return e.encodeJcc(&buf, ins, 0x87)
-	case "jb":
-		return e.encodeJcc(&buf, ins, 0x82)
-	case "call":
-		return e.encodeCall(&buf, ins)
-	case "ret":
-		buf.WriteByte(0xC3)
-		return buf.Bytes(), nil
-	case "push":
-		return e.encodePush(&buf, ins)
-	case "pop":
-		return e.encodePop(&buf, ins)
-	case "syscall":
-		buf.Write([]byte{0x0F, 0x05})
-		return buf.Bytes(), nil
-	case "nop":
-		buf.WriteByte(0x90)
-		return buf.Bytes(), nil
-	case "int":
-		return e.encodeInt(&buf, ins)
-	case "lea":
-		return e.encodeLea(&buf, ins)
-	case "test":
-		return e.encodeTest(&buf, ins)
-	default:
-		return nil, fmt.Errorf("unsupported instruction: %s", ins.Mnemonic)
+	return &Encoder{
+		BaseEncoder: arch.NewBaseEncoder(arch.ArchX86_64, 8, registerNumbers(false)),
+		disabled:    make(map[string]bool),
+		optimize:    true,
 	}
 }
 
-func (e *Encoder) encodeMov(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	if len(ins.Operands) != 2 {
-		return nil, fmt.Errorf("mov requires 2 operands")
+// NewX86Encoder returns an encoder for 32-bit x86. It shares the
+// instruction table with x86-64 but defaults to 32-bit mode, knows only
+// the eight legacy general registers and cannot switch to bits 64.
+func NewX86Encoder() *Encoder {
+	return &Encoder{
+		BaseEncoder: arch.NewBaseEncoder(arch.ArchX86, 4, registerNumbers(true)),
+		disabled:    make(map[string]bool),
+		optimize:    true,
 	}
+}
 
-	dst := ins.Operands[0]
-	src := ins.Operands[1]
-
-	if rd, ok := dst.(ast.RegOperand); ok {
-		regID, ok := e.Registers()[strings.ToLower(rd.Name)]
-		if !ok {
-			return nil, fmt.Errorf("unknown register: %s", rd.Name)
-		}
+// DisableOptimization turns off immediate size optimization, so that
+// immediates are encoded at the full operand size: mov r64 always takes
+// an imm64 and ALU instructions never use a sign-extended imm8.
+func (e *Encoder) DisableOptimization() {
+	e.optimize = false
+}
 
-		switch s := src.(type) {
-		case ast.ImmOperand:
-			return e.encodeMovRegImm(buf, regID, s.Val, rd.Name)
-		case ast.RegOperand:
-			srcID, ok := e.Registers()[strings.ToLower(s.Name)]
-			if !ok {
-				return nil, fmt.Errorf("unknown register: %s", s.Name)
-			}
-			return e.encodeMovRegReg(buf, regID, srcID, rd.Name, s.Name)
-		case ast.MemOperand:
-			return e.encodeMovRegMem(buf, regID, s, rd.Name)
-		case ast.LabelOperand:
-			e.writeRexIfNeeded(buf, regID)
-			buf.WriteByte(byte(0xB8 | (regID & 7)))
-			buf.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0})
-			return buf.Bytes(), nil
-		default:
-			return nil, fmt.Errorf("unsupported mov src: %T", src)
+// DisableFeatures rejects instructions that need any of the named CPU
+// features, such as BMI2 or AVX512F, so code can be restricted to what a
+// target machine supports.
+func (e *Encoder) DisableFeatures(names ...string) error {
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if !knownFeature(name) {
+			return fmt.Errorf("unknown CPU feature: %s", name)
 		}
+		e.disabled[name] = true
 	}
+	return nil
+}
 
-	if md, ok := dst.(ast.MemOperand); ok {
-		if rs, ok := src.(ast.RegOperand); ok {
-			regID, ok := e.Registers()[strings.ToLower(rs.Name)]
-			if !ok {
-				return nil, fmt.Errorf("unknown register: %s", rs.Name)
+func knownFeature(name string) bool {
+	for _, candidates := range forms {
+		for _, f := range candidates {
+			for _, feature := range f.features {
+				if feature == name {
+					return true
+				}
 			}
-			return e.encodeMovMemReg(buf, md, regID, rs.Name)
-		}
-		if imm, ok := src.(ast.ImmOperand); ok {
-			return e.encodeMovMemImm(buf, md, imm.Val)
 		}
 	}
-
-	return nil, fmt.Errorf("unsupported mov operands: %T <- %T", dst, src)
+	return false
 }
 
-func (e *Encoder) encodeMovRegImm(buf *bytes.Buffer, regID int, val ast.Expr, regName string) ([]byte, error) {
-	if num, ok := val.(ast.NumberExpr); ok {
-		e.writeRex(buf, 0, regID, true, regName)
-		op := byte(0xB8 | byte(regID&7))
-		buf.WriteByte(op)
-		var tmp [8]byte
-		binary.LittleEndian.PutUint64(tmp[:], uint64(num.Val))
-		buf.Write(tmp[:])
-		return buf.Bytes(), nil
-	}
-	return nil, fmt.Errorf("mov reg, imm requires NumberExpr for now")
+type output struct {
+	bytes.Buffer
+	fixups     []arch.Fixup
+	defaultRel bool
+	mode       int
 }
 
-func (e *Encoder) encodeMovRegReg(buf *bytes.Buffer, dstID, srcID int, dstName, srcName string) ([]byte, error) {
-	e.writeRex(buf, srcID, dstID, true, dstName)
-	buf.WriteByte(0x89)
-	e.writeModRM(buf, srcID, dstID, 0xC0)
-	return buf.Bytes(), nil
+func (o *output) fixup(size int, kind arch.RelocKind, symbol string, addend int64) {
+	o.fixups = append(o.fixups, arch.Fixup{
+		Offset: o.Len(),
+		Size:   size,
+		Kind:   kind,
+		Symbol: symbol,
+		Addend: addend,
+	})
 }
 
-func (e *Encoder) encodeMovRegMem(buf *bytes.Buffer, regID int, mem ast.MemOperand, regName string) ([]byte, error) {
-	e.writeRex(buf, regID, 0, true, regName)
-	buf.WriteByte(0x8B)
-	e.writeModRM(buf, regID, 0, 0x00)
-	return buf.Bytes(), nil
+func (e *Encoder) EncodeInstruction(ins *ast.Instruction, ctx *arch.Context) ([]byte, []arch.Fixup, error) {
+	buf := output{mode: e.WordSize() * 8}
+	if ctx != nil {
+		// Fill in the default mode so form matching can rely on ctx.Bits.
+		if ctx.Bits == 0 {
+			c := *ctx
+			c.Bits = buf.mode
+			ctx = &c
+		}
+		buf.defaultRel, buf.mode = ctx.DefaultRel, ctx.Bits
+	}
+	if buf.mode != 16 && buf.mode != 32 && buf.mode != 64 {
+		return nil, nil, fmt.Errorf("unsupported mode: bits %d", buf.mode)
+	}
+	if buf.mode == 64 && e.Arch() != arch.ArchX86_64 {
+		return nil, nil, fmt.Errorf("bits 64 is not supported on %s", e.Arch())
+	}
+	code, err := e.encode(&buf, ins, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range buf.fixups {
+		fx := &buf.fixups[i]
+		if fx.Kind == arch.RelocRel32 {
+			fx.Addend -= int64(len(code) - fx.Offset - fx.Size)
+		}
+	}
+	return code, buf.fixups, nil
 }
 
-func (e *Encoder) encodeMovMemReg(buf *bytes.Buffer, mem ast.MemOperand, regID int, regName string) ([]byte, error) {
-	e.writeRex(buf, regID, 0, true, regName)
-	buf.WriteByte(0x89)
-	e.writeModRM(buf, regID, 0, 0x00)
-	return buf.Bytes(), nil
-}
+func (e *Encoder) encode(buf *output, ins *ast.Instruction, ctx *arch.Context) ([]byte, error) {
+	candidates, ok := forms[strings.ToLower(ins.Mnemonic)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported instruction: %s", ins.Mnemonic)
+	}
+
+	args, err := e.resolveArgs(ins.Operands, buf.defaultRel, buf.mode)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ins.Mnemonic, err)
+	}
+	// An immediate with a size keyword picks its form even when
+	// optimization is off.
+	sized := false
+	for _, a := range args {
+		sized = sized || a.kind == argImm && a.size != 0
+	}
+	var usable []*form
+	for _, f := range candidates {
+		if f.validIn(buf.mode) && (e.optimize || sized || !f.shortImm()) {
+			usable = append(usable, f)
+		}
+	}
+	if len(usable) == 0 {
+		return nil, fmt.Errorf("%s is not available in %d-bit mode", ins.Mnemonic, buf.mode)
+	}
+	candidates = usable
 
-func (e *Encoder) encodeMovMemImm(buf *bytes.Buffer, mem ast.MemOperand, val ast.Expr) ([]byte, error) {
-	return nil, fmt.Errorf("mov mem, imm not yet implemented")
+	f, err := e.selectForm(ins, candidates, args, ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, feature := range f.features {
+		if e.disabled[feature] {
+			return nil, fmt.Errorf("%s: requires %s, which is disabled", ins.Mnemonic, feature)
+		}
+	}
+	if f.wait {
+		// FWAIT is an instruction of its own, so the prefixes belong
+		// to the one after it.
+		buf.WriteByte(0x9B)
+	}
+	if err := writeLegacyPrefixes(buf, f, ins.Prefixes, args); err != nil {
+		return nil, fmt.Errorf("%s: %v", ins.Mnemonic, err)
+	}
+	if err := e.emit(buf, f, args, ctx); err != nil {
+		return nil, fmt.Errorf("%s: %v", ins.Mnemonic, err)
+	}
+	return buf.Bytes(), nil
 }
 
-func (e *Encoder) encodeXor(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	if len(ins.Operands) != 2 {
-		return nil, fmt.Errorf("xor requires 2 operands")
+// selectForm returns the first form matching the operands. A memory
+// operand without a size is accepted as long as every form it could
+// match agrees on the size.
+func (e *Encoder) selectForm(ins *ast.Instruction, candidates []*form, args []arg, ctx *arch.Context) (*form, error) {
+	for _, f := range candidates {
+		if f.match(args, ctx, false) {
+			return f, nil
+		}
 	}
 
-	dst, ok := ins.Operands[0].(ast.RegOperand)
-	if !ok {
-		return nil, fmt.Errorf("xor dst must be register")
+	if len(args) == 1 && args[0].label != "" && candidates[0].rel != 0 {
+		return nil, fmt.Errorf("%s: %v", ins.Mnemonic, shortBranchError(args[0].label, ctx))
 	}
-	src, ok := ins.Operands[1].(ast.RegOperand)
-	if !ok {
-		return nil, fmt.Errorf("xor src must be register")
+	if err := fixedOperandError(ins.Mnemonic, candidates, args); err != nil {
+		return nil, err
+	}
+	if _, err := e.operandSize(ins.Operands...); err != nil {
+		return nil, err
 	}
 
-	dstID, ok := e.Registers()[strings.ToLower(dst.Name)]
-	if !ok {
-		return nil, fmt.Errorf("unknown register: %s", dst.Name)
+	var loose []*form
+	for _, f := range candidates {
+		if f.match(args, ctx, true) {
+			loose = append(loose, f)
+		}
 	}
-	srcID, ok := e.Registers()[strings.ToLower(src.Name)]
-	if !ok {
-		return nil, fmt.Errorf("unknown register: %s", src.Name)
+	if len(loose) == 0 {
+		return nil, fmt.Errorf("%s: invalid combination of operands", ins.Mnemonic)
 	}
-
-	e.writeRexIfNeeded(buf, dstID, srcID)
-	buf.WriteByte(0x31)
-	e.writeModRM(buf, srcID, dstID, 0xC0)
-	return buf.Bytes(), nil
+	for i, a := range args {
+		if a.kind != argMem || a.size != 0 {
+			continue
+		}
+		for _, f := range loose[1:] {
+			if f.operands[i].size != loose[0].operands[i].size {
+				return nil, fmt.Errorf("%s: operation size not specified", ins.Mnemonic)
+			}
+		}
+	}
+	return loose[0], nil
 }
 
-func (e *Encoder) encodeAdd(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	return e.encodeArithRR(buf, ins, 0x03, 0x01, 0x81, 0x83, 0)
+// fixedOperandError explains a register operand in a position where
+// every form wants a particular register or an immediate, such as the
+// count of a shift, which must be cl or an imm8.
+func fixedOperandError(mnemonic string, candidates []*form, args []arg) error {
+	for i, a := range args {
+		if a.kind != argReg {
+			continue
+		}
+		var regs, imms []string
+		free := false
+		for _, f := range candidates {
+			if len(f.operands) != len(args) {
+				continue
+			}
+			switch spec := f.operands[i]; spec.kind {
+			case kindFixed:
+				free = free || spec.reg == a.reg.name
+				if !slices.Contains(regs, spec.reg) {
+					regs = append(regs, spec.reg)
+				}
+			case kindImm, kindSImm, kindUImm:
+				if imm := fmt.Sprintf("imm%d", spec.size*8); !slices.Contains(imms, imm) {
+					imms = append(imms, imm)
+				}
+			case kindOne:
+			default:
+				free = true
+			}
+		}
+		if choices := append(regs, imms...); !free && len(choices) > 0 {
+			return fmt.Errorf("%s: operand %d must be %s", mnemonic, i+1, orList(choices))
+		}
+	}
+	return nil
 }
 
-func (e *Encoder) encodeSub(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	return e.encodeArithRR(buf, ins, 0x2B, 0x29, 0x81, 0x83, 5)
+// orList joins choices as "a, b or c".
+func orList(choices []string) string {
+	if len(choices) == 1 {
+		return choices[0]
+	}
+	return strings.Join(choices[:len(choices)-1], ", ") + " or " + choices[len(choices)-1]
 }
 
-func (e *Encoder) encodeCmp(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	return e.encodeArithRR(buf, ins, 0x3B, 0x39, 0x81, 0x83, 7)
+var legacyPrefixes = map[string]byte{
+	"lock":  0xF0,
+	"rep":   0xF3,
+	"repe":  0xF3,
+	"repz":  0xF3,
+	"repne": 0xF2,
+	"repnz": 0xF2,
 }
 
-func (e *Encoder) encodeArithRR(buf *bytes.Buffer, ins *ast.Instruction, opRegReg, opRegRegAlt, opImm32, opImm8, extField int) ([]byte, error) {
-	if len(ins.Operands) != 2 {
-		return nil, fmt.Errorf("%s requires 2 operands", ins.Mnemonic)
+func writeLegacyPrefixes(buf *output, f *form, prefixes []string, args []arg) error {
+	seen := make(map[byte]bool)
+	for _, p := range prefixes {
+		b, ok := legacyPrefixes[p]
+		if !ok {
+			return fmt.Errorf("unknown prefix %s", p)
+		}
+		if seen[b] || b != 0xF0 && (seen[0xF2] || seen[0xF3]) {
+			return fmt.Errorf("duplicate %s prefix", p)
+		}
+		seen[b] = true
+		switch {
+		case b == 0xF0:
+			if !f.lock {
+				return fmt.Errorf("lock prefix cannot be used with %s", f.mnemonic)
+			}
+			if !hasMemory(args) {
+				return fmt.Errorf("lock prefix requires a memory destination")
+			}
+		case !f.repe && !(f.rep && p == "rep"):
+			return fmt.Errorf("%s prefix cannot be used with %s", p, f.mnemonic)
+		}
+		buf.WriteByte(b)
 	}
+	return nil
+}
 
-	dst, ok := ins.Operands[0].(ast.RegOperand)
-	if !ok {
-		return nil, fmt.Errorf("%s dst must be register", ins.Mnemonic)
-	}
-	dstID, ok := e.Registers()[strings.ToLower(dst.Name)]
-	if !ok {
-		return nil, fmt.Errorf("unknown register: %s", dst.Name)
+func hasMemory(args []arg) bool {
+	for _, a := range args {
+		if a.kind == argMem {
+			return true
+		}
 	}
+	return false
+}
 
-	switch src := ins.Operands[1].(type) {
-	case ast.RegOperand:
-		srcID, ok := e.Registers()[strings.ToLower(src.Name)]
-		if !ok {
-			return nil, fmt.Errorf("unknown register: %s", src.Name)
-		}
-		e.writeRex(buf, dstID, srcID, true, dst.Name)
-		buf.WriteByte(byte(opRegReg))
-		e.writeModRM(buf, dstID, srcID, 0xC0)
-	case ast.ImmOperand:
-		if num, ok := src.Val.(ast.NumberExpr); ok {
-			if num.Val >= -128 && num.Val <= 127 {
-				e.writeRex(buf, extField, dstID, true, dst.Name)
-				buf.WriteByte(byte(opImm8))
-				e.writeModRM(buf, extField, dstID, 0xC0)
-				buf.WriteByte(byte(num.Val))
-			} else {
-				e.writeRex(buf, extField, dstID, true, dst.Name)
-				buf.WriteByte(byte(opImm32))
-				e.writeModRM(buf, extField, dstID, 0xC0)
-				var tmp [4]byte
-				binary.LittleEndian.PutUint32(tmp[:], uint32(num.Val))
-				buf.Write(tmp[:])
+type argKind int
+
+const (
+	argReg argKind = iota
+	argMem
+	argImm
+	argRound
+	argFar
+)
+
+// arg is a resolved operand. mask, zero and bcst carry the AVX-512
+// decorators and round the mode of a {rn-sae} style operand. A far
+// pointer keeps its offset in imm and its selector in sel.
+type arg struct {
+	kind  argKind
+	size  int
+	reg   register
+	mem   memRef
+	imm   value
+	sel   value
+	label string
+	mask  int
+	zero  bool
+	bcst  int
+	round string
+}
+
+func (e *Encoder) resolveArgs(ops []ast.Operand, defaultRel bool, mode int) ([]arg, error) {
+	args := make([]arg, len(ops))
+	for i, op := range ops {
+		a := &args[i]
+		switch o := op.(type) {
+		case ast.RegOperand:
+			r, err := e.modeReg(o.Name, mode)
+			if err != nil {
+				return nil, err
 			}
-		} else {
-			return nil, fmt.Errorf("%s immediate requires NumberExpr", ins.Mnemonic)
+			a.kind, a.reg, a.size, a.zero = argReg, r, r.size, o.Zero
+			if a.mask, err = e.maskReg(o.Mask); err != nil {
+				return nil, err
+			}
+		case ast.MemOperand:
+			m, err := e.resolveMem(o, defaultRel, mode)
+			if err != nil {
+				return nil, err
+			}
+			a.kind, a.mem, a.size, a.bcst = argMem, m, o.Size, o.Bcst
+			if a.mask, err = e.maskReg(o.Mask); err != nil {
+				return nil, err
+			}
+		case ast.ImmOperand:
+			v, err := evalValue(o.Val)
+			if err != nil {
+				return nil, err
+			}
+			a.kind, a.imm, a.size = argImm, v, o.Size
+		case ast.LabelOperand:
+			a.kind, a.imm, a.label = argImm, value{sym: o.Name}, o.Name
+		case ast.FarOperand:
+			sel, err := evalValue(o.Seg)
+			if err != nil {
+				return nil, err
+			}
+			off, err := evalValue(o.Off)
+			if err != nil {
+				return nil, err
+			}
+			a.kind, a.sel, a.imm, a.size = argFar, sel, off, o.Size
+			if a.size == 0 {
+				a.size = min(mode/8, 4)
+			}
+		case ast.RoundingOperand:
+			if i != len(ops)-1 {
+				return nil, fmt.Errorf("{%s} must be the last operand", o.Mode)
+			}
+			a.kind, a.round = argRound, o.Mode
+		default:
+			return nil, fmt.Errorf("unsupported operand %T", op)
 		}
-	default:
-		return nil, fmt.Errorf("%s unsupported src operand: %T", ins.Mnemonic, src)
 	}
-
-	return buf.Bytes(), nil
+	return args, nil
 }
 
-func (e *Encoder) encodeInc(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	if len(ins.Operands) != 1 {
-		return nil, fmt.Errorf("inc requires 1 operand")
-	}
-	rd, ok := ins.Operands[0].(ast.RegOperand)
-	if !ok {
-		return nil, fmt.Errorf("inc operand must be register")
+func (e *Encoder) maskReg(name string) (int, error) {
+	if name == "" {
+		return 0, nil
 	}
-	regID, ok := e.Registers()[strings.ToLower(rd.Name)]
-	if !ok {
-		return nil, fmt.Errorf("unknown register: %s", rd.Name)
+	r, err := e.reg(name)
+	if err != nil {
+		return 0, err
 	}
-	e.writeRex(buf, 0, regID, true, rd.Name)
-	buf.WriteByte(0xFF)
-	e.writeModRM(buf, 0, regID, 0xC0)
-	return buf.Bytes(), nil
+	return r.num, nil
 }
 
-func (e *Encoder) encodeDec(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	if len(ins.Operands) != 1 {
-		return nil, fmt.Errorf("dec requires 1 operand")
+func (f *form) match(args []arg, ctx *arch.Context, lenient bool) bool {
+	if n := len(args); n > 0 && args[n-1].kind == argRound {
+		sae := args[n-1].round == "sae"
+		if !(f.er && !sae || f.sae && sae) || hasMemory(args) {
+			return false
+		}
+		args = args[:n-1]
 	}
-	rd, ok := ins.Operands[0].(ast.RegOperand)
-	if !ok {
-		return nil, fmt.Errorf("dec operand must be register")
+	if len(args) != len(f.operands) {
+		return false
 	}
-	regID, ok := e.Registers()[strings.ToLower(rd.Name)]
-	if !ok {
-		return nil, fmt.Errorf("unknown register: %s", rd.Name)
+	for i, spec := range f.operands {
+		a := args[i]
+		if a.mask != 0 && !spec.mask || a.zero && !spec.zero || a.bcst != 0 && spec.bcst == 0 {
+			return false
+		}
+		if a.kind == argReg && a.reg.num >= 16 && !f.evex {
+			return false
+		}
+		var ok bool
+		switch spec.kind {
+		case kindReg:
+			ok = a.kind == argReg && spec.matchesReg(a.reg)
+		case kindFixed:
+			ok = a.kind == argReg && a.reg.name == spec.reg
+		case kindRM:
+			ok = a.kind == argReg && spec.matchesReg(a.reg) ||
+				a.kind == argMem && a.bcst != 0 && a.bcst*spec.bcst == spec.size && (a.size == 0 || a.size == spec.bcst) ||
+				a.kind == argMem && a.bcst == 0 && f.memSizeOK(args, i, lenient)
+		case kindMem:
+			ok = a.kind == argMem && (spec.size == 0 || f.memSizeOK(args, i, lenient))
+		case kindImm, kindSImm, kindUImm:
+			ok = a.kind == argImm && (a.size == 0 || a.size == spec.size) && f.immOK(spec, a.imm)
+		case kindOne:
+			ok = a.kind == argImm && a.size <= 1 && a.imm.sym == "" && a.imm.addend == 1
+		case kindRel:
+			ok = a.label != "" && f.relOK(a.label, spec.size, ctx)
+		case kindFar:
+			ok = a.kind == argFar && a.size == spec.size && immFits(a.sel.addend, 2)
+		}
+		if !ok {
+			return false
+		}
 	}
-	e.writeRex(buf, 1, regID, true, rd.Name)
-	buf.WriteByte(0xFF)
-	e.writeModRM(buf, 1, regID, 0xC0)
-	return buf.Bytes(), nil
+	return true
 }
 
-func (e *Encoder) encodeJmp(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	if len(ins.Operands) != 1 {
-		return nil, fmt.Errorf("jmp requires 1 operand")
-	}
-	_, ok := ins.Operands[0].(ast.LabelOperand)
-	if !ok {
-		return nil, fmt.Errorf("jmp operand must be label")
+// memSizeOK reports whether memory operand i fits its pattern. A memory
+// operand without a size specifier takes its size from a register
+// operand of the same width.
+func (f *form) memSizeOK(args []arg, i int, lenient bool) bool {
+	size := f.operands[i].size
+	if args[i].size != 0 {
+		return args[i].size == size
+	}
+	if lenient || f.d64 {
+		return true
+	}
+	for j, a := range args {
+		kind := f.operands[j].kind
+		if j != i && a.kind == argReg && (kind == kindReg || kind == kindRM) && a.reg.size == size {
+			return true
+		}
 	}
-	buf.WriteByte(0xE9)
-	buf.Write([]byte{0x00, 0x00, 0x00, 0x00})
-	return buf.Bytes(), nil
+	return false
 }
 
-func (e *Encoder) encodeJcc(buf *bytes.Buffer, ins *ast.Instruction, opcode2 byte) ([]byte, error) {
-	if len(ins.Operands) != 1 {
-		return nil, fmt.Errorf("%s requires 1 operand", ins.Mnemonic)
+func (f *form) immOK(spec operandSpec, v value) bool {
+	switch spec.kind {
+	case kindSImm:
+		n := signExtend(v.addend, f.opSize)
+		return v.sym == "" && immFits(v.addend, f.opSize) && n == signExtend(n, spec.size)
+	case kindUImm:
+		return v.sym == "" && v.addend >= 0 && immFits(v.addend, spec.size)
 	}
-	_, ok := ins.Operands[0].(ast.LabelOperand)
-	if !ok {
-		return nil, fmt.Errorf("%s operand must be label", ins.Mnemonic)
+	if v.sym != "" {
+		return spec.size >= 2
 	}
-	buf.Write([]byte{0x0F, opcode2})
-	buf.Write([]byte{0x00, 0x00, 0x00, 0x00})
-	return buf.Bytes(), nil
+	if spec.size == 4 && f.opSize == 8 {
+		return fitsInt32(v.addend)
+	}
+	return immFits(v.addend, spec.size)
 }
 
-func (e *Encoder) encodeCall(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	if len(ins.Operands) != 1 {
-		return nil, fmt.Errorf("call requires 1 operand")
+func (f *form) relOK(name string, size int, ctx *arch.Context) bool {
+	if size != 1 {
+		return true
 	}
-	_, ok := ins.Operands[0].(ast.LabelOperand)
-	if !ok {
-		return nil, fmt.Errorf("call operand must be label")
+	target, local, err := resolveBranch(name, ctx)
+	if err != nil || !local {
+		return false
 	}
-	buf.WriteByte(0xE8)
-	buf.Write([]byte{0x00, 0x00, 0x00, 0x00})
-	return buf.Bytes(), nil
+	n := len(f.prefixes) + len(f.opcode) + 1
+	if f.sizePrefix(ctx.Bits) {
+		n++
+	}
+	if f.addrSize != 0 && f.addrSize != ctx.Bits/8 {
+		n++
+	}
+	rel := target - int64(ctx.PC) - int64(n)
+	return fitsInt8(rel) && ctx.MinSize <= n
 }
 
-func (e *Encoder) encodePush(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	if len(ins.Operands) != 1 {
-		return nil, fmt.Errorf("push requires 1 operand")
+func (e *Encoder) emit(buf *output, f *form, args []arg, ctx *arch.Context) error {
+	reg := ext(max(f.digit, 0))
+	hasReg := f.digit >= 0
+	var opReg, vreg register
+	var rm *arg
+	var rmSize int
+	var imms []value
+	var label string
+	var far *arg
+	for i, spec := range f.operands {
+		a := &args[i]
+		if a.kind == argMem {
+			rmSize = spec.size
+			if a.bcst != 0 {
+				rmSize = spec.bcst
+			}
+		}
+		if i == f.vvvv {
+			vreg = a.reg
+			continue
+		}
+		switch spec.kind {
+		case kindReg:
+			if f.plusReg {
+				opReg = a.reg
+			} else if hasReg || f.mr && rm == nil {
+				rm = a
+			} else {
+				reg, hasReg = a.reg, true
+			}
+		case kindRM, kindMem:
+			rm = a
+		case kindImm, kindSImm, kindUImm:
+			imms = append(imms, a.imm)
+		case kindRel:
+			label = a.label
+		case kindFar:
+			far = a
+		}
 	}
-	rd, ok := ins.Operands[0].(ast.RegOperand)
-	if !ok {
-		return nil, fmt.Errorf("push operand must be register")
+
+	base, index := opReg, 0
+	addrSize := f.addrSize
+	if rm != nil && rm.kind == argReg {
+		base = rm.reg
+	} else if rm != nil {
+		if rm.mem.seg != 0 {
+			buf.WriteByte(rm.mem.seg)
+		}
+		addrSize = rm.mem.addrSize
+		if rm.mem.base != noReg {
+			base = ext(rm.mem.base)
+		}
+		if rm.mem.index != noReg {
+			index = rm.mem.index
+		}
 	}
-	regID, ok := e.Registers()[strings.ToLower(rd.Name)]
-	if !ok {
-		return nil, fmt.Errorf("unknown register: %s", rd.Name)
+	if addrSize != 0 && addrSize != buf.mode/8 {
+		buf.WriteByte(0x67)
 	}
-	if regID >= 8 {
-		buf.WriteByte(0x41)
+	if f.evex {
+		writeEvex(buf, f, reg, base, index, vreg, args)
+		if rm != nil && rm.kind == argMem {
+			rm.mem.dispScale = rmSize
+		}
+	} else if f.vex {
+		if err := writeVex(buf, f, reg, base, index, vreg); err != nil {
+			return err
+		}
+	} else {
+		if f.sizePrefix(buf.mode) {
+			buf.WriteByte(0x66)
+		}
+		buf.Write(f.prefixes)
+		if err := writeRex(buf, f.rexW, reg, base, index); err != nil {
+			return err
+		}
 	}
-	buf.WriteByte(byte(0x50 | (regID & 7)))
-	return buf.Bytes(), nil
-}
 
-func (e *Encoder) encodePop(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	if len(ins.Operands) != 1 {
-		return nil, fmt.Errorf("pop requires 1 operand")
+	last := len(f.opcode) - 1
+	buf.Write(f.opcode[:last])
+	if f.plusReg {
+		buf.WriteByte(f.opcode[last] | byte(opReg.num&7))
+	} else {
+		buf.WriteByte(f.opcode[last])
 	}
-	rd, ok := ins.Operands[0].(ast.RegOperand)
-	if !ok {
-		return nil, fmt.Errorf("pop operand must be register")
+
+	if f.modrm {
+		if rm.kind == argReg {
+			e.writeModRM(buf, reg.num, rm.reg.num, 0xC0)
+		} else {
+			writeMem(buf, reg.num, rm.mem)
+		}
 	}
-	regID, ok := e.Registers()[strings.ToLower(rd.Name)]
-	if !ok {
-		return nil, fmt.Errorf("unknown register: %s", rd.Name)
+
+	for i, v := range imms {
+		if err := writeValue(buf, v, f.imms[i]); err != nil {
+			return err
+		}
 	}
-	if regID >= 8 {
-		buf.WriteByte(0x41)
+	if far != nil {
+		if err := writeValue(buf, far.imm, far.size); err != nil {
+			return err
+		}
+		if err := writeValue(buf, far.sel, 2); err != nil {
+			return err
+		}
 	}
-	buf.WriteByte(byte(0x58 | (regID & 7)))
-	return buf.Bytes(), nil
-}
 
-func (e *Encoder) encodeInt(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	if len(ins.Operands) != 1 {
-		return nil, fmt.Errorf("int requires 1 operand")
-	}
-	imm, ok := ins.Operands[0].(ast.ImmOperand)
-	if !ok {
-		return nil, fmt.Errorf("int operand must be immediate")
+	if label != "" {
+		size := f.rel
+		if size == 4 && buf.mode == 16 {
+			size = 2
+		}
+		return writeRel(buf, label, size, ctx)
 	}
-	num, ok := imm.Val.(ast.NumberExpr)
-	if !ok {
-		return nil, fmt.Errorf("int immediate must be number")
+	return nil
+}
+
+// sizePrefix reports whether the form needs the operand-size prefix: 16-bit
+// forms outside 16-bit mode and 32-bit forms inside it.
+func (f *form) sizePrefix(mode int) bool {
+	if mode == 16 {
+		return f.o32
 	}
-	buf.WriteByte(0xCD)
-	buf.WriteByte(byte(num.Val))
-	return buf.Bytes(), nil
+	return f.o16
 }
 
-func (e *Encoder) encodeLea(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	return nil, fmt.Errorf("lea not yet implemented")
+func writeRel(buf *output, name string, size int, ctx *arch.Context) error {
+	target, local, err := resolveBranch(name, ctx)
+	if err != nil {
+		return err
+	}
+	if !local {
+		if size != 4 {
+			return fmt.Errorf("%s is not in the current section", name)
+		}
+		buf.fixup(4, arch.RelocRel32, name, 0)
+		writeImm32(buf, 0)
+		return nil
+	}
+	rel := target - int64(ctx.PC) - int64(buf.Len()+size)
+	if rel != signExtend(rel, size) {
+		return fmt.Errorf("target %s out of range", name)
+	}
+	writeImm(buf, rel, size)
+	return nil
 }
 
-func (e *Encoder) encodeTest(buf *bytes.Buffer, ins *ast.Instruction) ([]byte, error) {
-	return nil, fmt.Errorf("test not yet implemented")
+func shortBranchError(name string, ctx *arch.Context) error {
+	_, local, err := resolveBranch(name, ctx)
+	switch {
+	case err != nil:
+		return err
+	case !local:
+		return fmt.Errorf("%s is not in the current section", name)
+	}
+	return fmt.Errorf("short jump to %s is out of range", name)
 }
 
-func (e *Encoder) writeRex(buf *bytes.Buffer, regField, rmField int, needW bool, regName string) {
-	var rex byte = 0x40
-	if needW {
-		rex |= 0x08
+func resolveBranch(name string, ctx *arch.Context) (int64, bool, error) {
+	if ctx == nil || ctx.Lookup == nil {
+		return 0, false, fmt.Errorf("no layout context to resolve %s", name)
 	}
-	if regField >= 8 {
-		rex |= 0x04
+	sym, ok := ctx.Lookup(name)
+	if !ok {
+		return 0, false, fmt.Errorf("undefined label: %s", name)
 	}
-	if rmField >= 8 {
-		rex |= 0x01
+	if sym.Section != ctx.Section {
+		return 0, false, nil
 	}
-	buf.WriteByte(rex)
+	return int64(sym.Offset), true, nil
 }
 
-func (e *Encoder) writeRexIfNeeded(buf *bytes.Buffer, regs ...int) {
-	var rex byte = 0x48
-	var bbit byte = 0
-	for _, r := range regs {
-		if r >= 8 {
-			bbit = 1
-			break
+func (e *Encoder) operandSize(ops ...ast.Operand) (int, error) {
+	size := 0
+	var first string
+	for _, op := range ops {
+		var opSize int
+		var desc string
+		switch o := op.(type) {
+		case ast.RegOperand:
+			r, err := e.reg(o.Name)
+			if err != nil {
+				return 0, err
+			}
+			if r.class != classGPR {
+				continue
+			}
+			opSize, desc = r.size, r.name
+		case ast.MemOperand:
+			if o.Size == 0 {
+				continue
+			}
+			opSize, desc = o.Size, sizeName(o.Size)+" memory operand"
+		default:
+			continue
+		}
+		if size == 0 {
+			size, first = opSize, desc
+			continue
+		}
+		if opSize != size {
+			return 0, fmt.Errorf("operand size mismatch: %s and %s", first, desc)
 		}
 	}
-	if bbit == 1 {
-		rex |= 0x01
-	}
-	buf.WriteByte(rex)
+	return size, nil
 }
 
-func (e *Encoder) writeModRM(buf *bytes.Buffer, regField, rmField int, base byte) {
-	modrm := base | byte((regField&7)<<3) | byte(rmField&7)
-	buf.WriteByte(modrm)
+func sizeName(size int) string {
+	switch size {
+	case 1:
+		return "byte"
+	case 2:
+		return "word"
+	case 4:
+		return "dword"
+	case 8:
+		return "qword"
+	case 10:
+		return "tword"
+	case 16:
+		return "oword"
+	case 32:
+		return "yword"
+	case 64:
+		return "zword"
+	}
+	return fmt.Sprintf("%d-byte", size)
 }
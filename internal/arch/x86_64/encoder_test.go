@@ -0,0 +1,438 @@
+package x86_64
+
+import (
+	"fmt"
+	"gasm/internal/arch"
+	"gasm/internal/ast"
+	"gasm/internal/parser"
+	"strings"
+	"testing"
+)
+
+// testSymbols is the layout branch and rip-relative tests resolve against.
+var testSymbols = map[string]arch.Symbol{
+	"near": {Name: "near", Section: ".text", Offset: 0x10},
+	"far":  {Name: "far", Section: ".text", Offset: 0x1000},
+	"msg":  {Name: "msg", Section: ".data", Offset: 0},
+}
+
+type encodeTest struct {
+	src        string
+	want       string
+	bits       int
+	pc         uint64
+	minSize    int
+	defaultRel bool
+	fixups     []arch.Fixup
+}
+
+var encodeTests = []encodeTest{
+	// rsp and r12 as a base need a SIB byte, as does an absolute address.
+	{src: "mov rax, [rsp]", want: "48 8b 04 24"},
+	{src: "mov rax, [r12]", want: "49 8b 04 24"},
+	{src: "mov rax, [rsp+8]", want: "48 8b 44 24 08"},
+	{src: "mov rax, [r12+rcx*4]", want: "49 8b 04 8c"},
+	{src: "mov rax, [0x1000]", want: "48 8b 04 25 00 10 00 00"},
+	{src: "mov rdx, [rsp+rsi*2-0x80]", want: "48 8b 54 74 80"},
+
+	// rbp and r13 as a base need mod=01 with a zero displacement.
+	{src: "mov rax, [rbp]", want: "48 8b 45 00"},
+	{src: "mov rax, [r13]", want: "49 8b 45 00"},
+	{src: "mov rax, [rbp-8]", want: "48 8b 45 f8"},
+	{src: "mov rax, [r13+rcx*2]", want: "49 8b 44 4d 00"},
+
+	// Base, index, scale and displacement together.
+	{src: "mov [rax+rbx*8+0x100], rcx", want: "48 89 8c d8 00 01 00 00"},
+
+	// spl, bpl, sil and dil need an empty REX prefix; ah does not allow
+	// one. Other registers set the operand size.
+	{src: "mov al, sil", want: "40 88 f0"},
+	{src: "mov sil, al", want: "40 88 c6"},
+	{src: "mov dil, 1", want: "40 b7 01"},
+	{src: "mov spl, bpl", want: "40 88 ec"},
+	{src: "mov ah, al", want: "88 c4"},
+	{src: "mov ax, bx", want: "66 89 d8"},
+	{src: "mov r9w, 0x1234", want: "66 41 b9 34 12"},
+	{src: "mov r10d, [rax]", want: "44 8b 10"},
+	{src: "mov rax, r15", want: "4c 89 f8"},
+
+	// Size specifiers give memory operands their size.
+	{src: "mov dword [rax], 1", want: "c7 00 01 00 00 00"},
+	{src: "mov byte [rbx+4], 0x7f", want: "c6 43 04 7f"},
+	{src: "mov word [rcx], 0x1234", want: "66 c7 01 34 12"},
+	{src: "mov qword [rdx], -1", want: "48 c7 02 ff ff ff ff"},
+
+	// Branches use rel8 when the target is in range and the layout has
+	// not already grown the instruction.
+	{src: "jmp near", want: "eb 0e"},
+	{src: "jmp far", want: "e9 fb 0f 00 00"},
+	{src: "jmp near", minSize: 5, want: "e9 0b 00 00 00"},
+	{src: "jmp near", pc: 0x100, want: "e9 0b ff ff ff"},
+	{src: "jne near", want: "75 0e"},
+	{src: "jne far", want: "0f 85 fa 0f 00 00"},
+	{src: "call near", want: "e8 0b 00 00 00"},
+
+	// Symbols the layout cannot resolve are reported as fixups.
+	{src: "mov rax, msg", want: "48 b8 00 00 00 00 00 00 00 00",
+		fixups: []arch.Fixup{{Offset: 2, Size: 8, Kind: arch.RelocAbs64, Symbol: "msg"}}},
+	{src: "jmp msg", want: "e9 00 00 00 00",
+		fixups: []arch.Fixup{{Offset: 1, Size: 4, Kind: arch.RelocRel32, Symbol: "msg"}}},
+
+	// lea takes the address of any memory operand, rip-relative included.
+	{src: "lea rax, [rbx+rcx*4+8]", want: "48 8d 44 8b 08"},
+	{src: "lea r8, [rsp]", want: "4c 8d 04 24"},
+	{src: "lea rax, [rel msg]", want: "48 8d 05 00 00 00 00",
+		fixups: []arch.Fixup{{Offset: 3, Size: 4, Kind: arch.RelocRel32, Symbol: "msg"}}},
+
+	// rip-relative addressing, with a fixup for symbols in another section.
+	{src: "lea rsi, [rip+0x100]", want: "48 8d 35 00 01 00 00"},
+	{src: "mov eax, [rip+0x10]", want: "8b 05 10 00 00 00"},
+	{src: "mov dword [rel msg], 5", want: "c7 05 00 00 00 00 05 00 00 00",
+		fixups: []arch.Fixup{{Offset: 2, Size: 4, Kind: arch.RelocRel32, Symbol: "msg", Addend: -4}}},
+	{src: "mov eax, [msg]", defaultRel: true, want: "8b 05 00 00 00 00",
+		fixups: []arch.Fixup{{Offset: 2, Size: 4, Kind: arch.RelocRel32, Symbol: "msg"}}},
+	{src: "mov eax, [abs 0x10]", defaultRel: true, want: "8b 04 25 10 00 00 00"},
+
+	// test and the ALU group, with the accumulator short forms.
+	{src: "add eax, ebx", want: "01 d8"},
+	{src: "add al, 5", want: "04 05"},
+	{src: "add ax, 0x1234", want: "66 05 34 12"},
+	{src: "sub eax, 0x12345678", want: "2d 78 56 34 12"},
+	{src: "and rax, 0x7fffffff", want: "48 25 ff ff ff 7f"},
+	{src: "cmp byte [rax], 1", want: "80 38 01"},
+	{src: "xor r8, r9", want: "4d 31 c8"},
+	{src: "test eax, ecx", want: "85 c8"},
+	{src: "test al, 1", want: "a8 01"},
+	{src: "test dword [rbx], 0x100", want: "f7 03 00 01 00 00"},
+	{src: "or rdi, [rsi+8]", want: "48 0b 7e 08"},
+
+	// Shifts, rotates, multiply and divide.
+	{src: "shl eax, 1", want: "d1 e0"},
+	{src: "shr rbx, cl", want: "48 d3 eb"},
+	{src: "sar dword [rax], 3", want: "c1 38 03"},
+	{src: "rol r8b, 4", want: "41 c0 c0 04"},
+	{src: "imul rax, rbx", want: "48 0f af c3"},
+	{src: "imul eax, ecx, 10", want: "6b c1 0a"},
+	{src: "mul rcx", want: "48 f7 e1"},
+	{src: "div dword [rbx]", want: "f7 33"},
+	{src: "neg rax", want: "48 f7 d8"},
+	{src: "not byte [rdi]", want: "f6 17"},
+
+	// Condition codes.
+	{src: "cmove eax, ebx", want: "0f 44 c3"},
+	{src: "cmovne rax, [rbx]", want: "48 0f 45 03"},
+	{src: "sete al", want: "0f 94 c0"},
+	{src: "setg byte [rcx]", want: "0f 9f 01"},
+
+	// String instructions and their rep prefixes.
+	{src: "movsb", want: "a4"},
+	{src: "rep movsq", want: "f3 48 a5"},
+	{src: "repe cmpsb", want: "f3 a6"},
+	{src: "repne scasb", want: "f2 ae"},
+	{src: "rep stosd", want: "f3 ab"},
+	{src: "lodsq", want: "48 ad"},
+
+	// lock prefix, exchanges and fences.
+	{src: "lock add [rax], ebx", want: "f0 01 18"},
+	{src: "lock cmpxchg [rdi], rcx", want: "f0 48 0f b1 0f"},
+	{src: "xchg rax, rbx", want: "48 93"},
+	{src: "xchg [rsi], eax", want: "87 06"},
+	{src: "lock xadd [rax], edx", want: "f0 0f c1 10"},
+	{src: "mfence", want: "0f ae f0"},
+	{src: "lfence", want: "0f ae e8"},
+	{src: "sfence", want: "0f ae f8"},
+
+	// SSE and SSE2; xmm8-xmm15 need REX like the general registers.
+	{src: "addps xmm0, xmm1", want: "0f 58 c1"},
+	{src: "addsd xmm9, [rax]", want: "f2 44 0f 58 08"},
+	{src: "movaps xmm1, [rbx]", want: "0f 28 0b"},
+	{src: "movdqu xmm2, xmm10", want: "f3 41 0f 6f d2"},
+	{src: "pxor xmm3, xmm4", want: "66 0f ef dc"},
+	{src: "cvtsi2sd xmm0, rax", want: "f2 48 0f 2a c0"},
+	{src: "cvttsd2si eax, xmm1", want: "f2 0f 2c c1"},
+	{src: "movq rax, xmm0", want: "66 48 0f 7e c0"},
+	{src: "shufps xmm1, xmm2, 0x1b", want: "0f c6 ca 1b"},
+
+	// VEX encodings of AVX, AVX2 and FMA.
+	{src: "vaddps ymm0, ymm1, ymm2", want: "c5 f4 58 c2"},
+	{src: "vaddpd xmm1, xmm2, [rax]", want: "c5 e9 58 08"},
+	{src: "vmovdqu ymm8, [rcx]", want: "c5 7e 6f 01"},
+	{src: "vpaddd ymm1, ymm2, ymm12", want: "c4 c1 6d fe cc"},
+	{src: "vfmadd231ps xmm1, xmm2, xmm3", want: "c4 e2 69 b8 cb"},
+	{src: "vxorps xmm0, xmm0, xmm0", want: "c5 f8 57 c0"},
+
+	// EVEX: zmm and xmm16-31, masking, zeroing, broadcast and rounding;
+	// disp8 is scaled by the memory operand size.
+	{src: "vaddps zmm0, zmm1, zmm2", want: "62 f1 74 48 58 c2"},
+	{src: "vaddps zmm0{k1}, zmm1, zmm2", want: "62 f1 74 49 58 c2"},
+	{src: "vaddps zmm0{k1}{z}, zmm1, zmm2", want: "62 f1 74 c9 58 c2"},
+	{src: "vaddps zmm0, zmm1, dword [rax]{1to16}", want: "62 f1 74 58 58 00"},
+	{src: "vaddpd zmm0, zmm1, qword [rax+8]{1to8}", want: "62 f1 f5 58 58 40 01"},
+	{src: "vaddps zmm0, zmm1, zmm2, {rn-sae}", want: "62 f1 74 18 58 c2"},
+	{src: "vaddps zmm0, zmm1, zmm2, {rz-sae}", want: "62 f1 74 78 58 c2"},
+	{src: "vaddps xmm16, xmm17, xmm18", want: "62 a1 74 00 58 c2"},
+	{src: "vmovdqu32 zmm1{k2}, [rax+64]", want: "62 f1 7e 4a 6f 48 01"},
+	{src: "kmovw k1, eax", want: "c5 f8 92 c8"},
+	{src: "vpaddd zmm1, zmm2, [rax+128]", want: "62 f1 6d 48 fe 48 02"},
+
+	// x87 memory and st(i) forms.
+	{src: "fld dword [rax]", want: "d9 00"},
+	{src: "fld qword [rbx]", want: "dd 03"},
+	{src: "fld tword [rcx]", want: "db 29"},
+	{src: "fld st1", want: "d9 c1"},
+	{src: "fadd st0, st3", want: "d8 c3"},
+	{src: "fadd st3, st0", want: "dc c3"},
+	{src: "faddp st1, st0", want: "de c1"},
+	{src: "fstp tword [rdx]", want: "db 3a"},
+	{src: "fxch st2", want: "d9 ca"},
+	{src: "fild dword [rsp]", want: "db 04 24"},
+	{src: "fistp qword [rax]", want: "df 38"},
+	{src: "fninit", want: "db e3"},
+
+	// System instructions and port I/O.
+	{src: "cpuid", want: "0f a2"},
+	{src: "rdtsc", want: "0f 31"},
+	{src: "mov rax, cr0", want: "0f 20 c0"},
+	{src: "mov cr3, rbx", want: "0f 22 db"},
+	{src: "mov rdx, dr7", want: "0f 21 fa"},
+	{src: "hlt", want: "f4"},
+	{src: "invlpg [rax]", want: "0f 01 38"},
+	{src: "lgdt [rbx]", want: "0f 01 13"},
+	{src: "in al, dx", want: "ec"},
+	{src: "out 0x80, al", want: "e6 80"},
+	{src: "in eax, 0x60", want: "e5 60"},
+	{src: "wrmsr", want: "0f 30"},
+	{src: "iretq", want: "48 cf"},
+
+	// Bit manipulation.
+	{src: "bt eax, 3", want: "0f ba e0 03"},
+	{src: "bts qword [rax], rcx", want: "48 0f ab 08"},
+	{src: "bsf eax, ebx", want: "0f bc c3"},
+	{src: "bsr rax, [rcx]", want: "48 0f bd 01"},
+	{src: "popcnt eax, ebx", want: "f3 0f b8 c3"},
+	{src: "lzcnt rax, rbx", want: "f3 48 0f bd c3"},
+	{src: "tzcnt ecx, edx", want: "f3 0f bc ca"},
+	{src: "andn eax, ebx, ecx", want: "c4 e2 60 f2 c1"},
+	{src: "bextr rax, rbx, rcx", want: "c4 e2 f0 f7 c3"},
+	{src: "blsr eax, ebx", want: "c4 e2 78 f3 cb"},
+	{src: "pdep rax, rbx, rcx", want: "c4 e2 e3 f5 c1"},
+	{src: "shlx eax, ebx, ecx", want: "c4 e2 71 f7 c3"},
+	{src: "rorx rax, rbx, 5", want: "c4 e3 fb f0 c3 05"},
+
+	// AES, PCLMULQDQ and SHA.
+	{src: "aesenc xmm1, xmm2", want: "66 0f 38 dc ca"},
+	{src: "aesdeclast xmm1, [rax]", want: "66 0f 38 df 08"},
+	{src: "aeskeygenassist xmm1, xmm2, 1", want: "66 0f 3a df ca 01"},
+	{src: "vaesenc xmm1, xmm2, xmm3", want: "c4 e2 69 dc cb"},
+	{src: "vaesenc ymm1, ymm2, ymm3", want: "c4 e2 6d dc cb"},
+	{src: "vaesenc zmm1, zmm2, zmm3", want: "62 f2 6d 48 dc cb"},
+	{src: "pclmulqdq xmm1, xmm2, 0x11", want: "66 0f 3a 44 ca 11"},
+	{src: "vpclmulqdq xmm1, xmm2, xmm3, 0", want: "c4 e3 69 44 cb 00"},
+	{src: "sha256rnds2 xmm1, xmm2", want: "0f 38 cb ca"},
+	{src: "sha1rnds4 xmm1, xmm2, 3", want: "0f 3a cc ca 03"},
+
+	// mov and ALU immediates take the shortest encoding.
+	{src: "mov rax, 1", want: "b8 01 00 00 00"},
+	{src: "mov rax, -1", want: "48 c7 c0 ff ff ff ff"},
+	{src: "mov rax, 0x123456789", want: "48 b8 89 67 45 23 01 00 00 00"},
+	{src: "add rax, 1", want: "48 83 c0 01"},
+	{src: "add eax, 1000", want: "05 e8 03 00 00"},
+	{src: "and ecx, -2", want: "83 e1 fe"},
+
+	// Segment registers and overrides. fs and gs stay absolute under
+	// default rel.
+	{src: "mov eax, [fs:rax]", want: "64 8b 00"},
+	{src: "mov rax, [gs:0x30]", want: "65 48 8b 04 25 30 00 00 00"},
+	{src: "mov ds, ax", want: "66 8e d8"},
+	{src: "mov ax, ds", want: "66 8c d8"},
+	{src: "push fs", want: "0f a0"},
+	{src: "pop gs", want: "0f a9"},
+	{src: "rdfsbase rax", want: "f3 48 0f ae c0"},
+	{src: "wrgsbase rcx", want: "f3 48 0f ae d9"},
+	{src: "mov eax, [fs:msg]", defaultRel: true, want: "64 8b 04 25 00 00 00 00",
+		fixups: []arch.Fixup{{Offset: 4, Size: 4, Kind: arch.RelocAbs32, Symbol: "msg"}}},
+
+	// 16-bit mode uses bx/bp/si/di addressing and 66 for 32-bit operands.
+	{src: "mov ax, [bx+si]", bits: 16, want: "8b 00"},
+	{src: "mov ax, [bp]", bits: 16, want: "8b 46 00"},
+	{src: "mov ax, [bp+di+4]", bits: 16, want: "8b 43 04"},
+	{src: "mov al, [0x1234]", bits: 16, want: "8a 06 34 12"},
+	{src: "mov eax, [ebx]", bits: 16, want: "67 66 8b 03"},
+	{src: "mov eax, 5", bits: 16, want: "66 b8 05 00 00 00"},
+	{src: "push ax", bits: 16, want: "50"},
+	{src: "jmp 0x10:0x20", bits: 16, want: "ea 20 00 10 00"},
+
+	// 32-bit mode.
+	{src: "mov eax, [ebx+ecx*4+8]", bits: 32, want: "8b 44 8b 08"},
+	{src: "mov ax, [esi]", bits: 32, want: "66 8b 06"},
+	{src: "mov eax, [bx+si]", bits: 32, want: "67 8b 00"},
+	{src: "push eax", bits: 32, want: "50"},
+	{src: "pusha", bits: 32, want: "60"},
+	{src: "mov eax, cr0", bits: 32, want: "0f 20 c0"},
+
+	// Outside 64-bit mode inc and dec have one-byte register forms.
+	{src: "inc eax", bits: 32, want: "40"},
+	{src: "dec cx", bits: 32, want: "66 49"},
+	{src: "inc eax", want: "ff c0"},
+
+	// FWAIT comes before the prefixes of the store that follows it.
+	{src: "fstcw word [r8]", want: "9b 41 d9 38"},
+	{src: "fstsw word [fs:rax]", want: "9b 64 dd 38"},
+	{src: "fstcw word [eax]", bits: 32, want: "9b d9 38"},
+
+	// A size keyword on an immediate picks the width of its field.
+	{src: "add eax, byte 5", want: "83 c0 05"},
+	{src: "add eax, dword 5", want: "05 05 00 00 00"},
+	{src: "int byte 3", want: "cd 03"},
+}
+
+func TestEncodeInstruction(t *testing.T) {
+	e := NewEncoder()
+	for _, tt := range encodeTests {
+		checkEncoding(t, e, tt)
+	}
+}
+
+var encodeErrorTests = []struct {
+	src  string
+	bits int
+	want string
+}{
+	{src: "mov ah, sil", want: "ah cannot be encoded in an instruction requiring a REX prefix"},
+	{src: "frob eax", want: "unsupported instruction: frob"},
+	{src: "mov eax, bx", want: "operand size mismatch"},
+	{src: "mov r8d, 1", bits: 32, want: "r8d is not available in 32-bit mode"},
+	{src: "mov rax, [bx]", want: "bx cannot be used in an address in 64-bit mode"},
+	{src: "push rax", bits: 16, want: "rax is not available in 16-bit mode"},
+	{src: "shl rax, dl", want: "operand 2 must be cl or imm8"},
+	{src: "in al, cx", want: "operand 2 must be dx or imm8"},
+}
+
+func TestEncodeInstructionErrors(t *testing.T) {
+	e := NewEncoder()
+	for _, tt := range encodeErrorTests {
+		_, _, err := encode(t, e, encodeTest{src: tt.src, bits: tt.bits})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+// featureTests disables one CPU feature and encodes an instruction,
+// expecting an error containing want, or success if want is empty.
+var featureTests = []struct {
+	disable string
+	src     string
+	want    string
+}{
+	{"BMI2", "pdep rax, rbx, rcx", "requires BMI2"},
+	{"BMI2", "andn eax, ebx, ecx", ""},
+	{"POPCNT", "popcnt eax, ebx", "requires POPCNT"},
+	{"AES", "aesenc xmm1, xmm2", "requires AES"},
+	{"AVX", "vaesenc xmm1, xmm2, xmm3", "requires AVX"},
+	{"AVX", "aesenc xmm1, xmm2", ""},
+	{"AVX512F", "vaesenc zmm1, zmm2, zmm3", "requires AVX512F"},
+	{"AVX512F", "vaesenc ymm1, ymm2, ymm3", ""},
+}
+
+func TestDisableFeatures(t *testing.T) {
+	for _, tt := range featureTests {
+		e := NewEncoder()
+		if err := e.DisableFeatures(tt.disable); err != nil {
+			t.Fatalf("DisableFeatures(%s): %v", tt.disable, err)
+		}
+		_, _, err := encode(t, e, encodeTest{src: tt.src})
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s without %s: got error %v, want %q", tt.src, tt.disable, err, tt.want)
+		}
+	}
+	if err := NewEncoder().DisableFeatures("NOSUCH"); err == nil {
+		t.Error("DisableFeatures accepted an unknown feature")
+	}
+}
+
+// unoptimizedTests are encoded with immediate size optimization off.
+var unoptimizedTests = []encodeTest{
+	{src: "mov rax, 1", want: "48 b8 01 00 00 00 00 00 00 00"},
+	{src: "add rax, 1", want: "48 05 01 00 00 00"},
+	{src: "and ecx, -2", want: "81 e1 fe ff ff ff"},
+}
+
+func TestDisableOptimization(t *testing.T) {
+	e := NewEncoder()
+	e.DisableOptimization()
+	for _, tt := range unoptimizedTests {
+		checkEncoding(t, e, tt)
+	}
+}
+
+func TestX86Encoder(t *testing.T) {
+	e := NewX86Encoder()
+	checkEncoding(t, e, encodeTest{src: "inc eax", want: "40"})
+	checkEncoding(t, e, encodeTest{src: "mov eax, [ebx+4]", want: "8b 43 04"})
+	for _, tt := range []struct{ src, want string }{
+		{"mov r8d, 1", "r8d is not available in 32-bit mode"},
+		{"mov eax, [rbx]", "rbx is not available in 32-bit mode"},
+	} {
+		if _, _, err := encode(t, e, encodeTest{src: tt.src}); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+	if _, _, err := encode(t, e, encodeTest{src: "nop", bits: 64}); err == nil {
+		t.Error("x86 encoder accepted bits 64")
+	}
+}
+
+func checkEncoding(t *testing.T, e *Encoder, tt encodeTest) {
+	t.Helper()
+	code, fixups, err := encode(t, e, tt)
+	if err != nil {
+		t.Errorf("%s: %v", tt.src, err)
+		return
+	}
+	if got := fmt.Sprintf("% x", code); got != tt.want {
+		t.Errorf("%s: got %s, want %s", tt.src, got, tt.want)
+	}
+	if len(fixups) != len(tt.fixups) {
+		t.Errorf("%s: got fixups %+v, want %+v", tt.src, fixups, tt.fixups)
+		return
+	}
+	for i := range fixups {
+		if fixups[i] != tt.fixups[i] {
+			t.Errorf("%s: got fixup %+v, want %+v", tt.src, fixups[i], tt.fixups[i])
+		}
+	}
+}
+
+func encode(t *testing.T, e *Encoder, tt encodeTest) ([]byte, []arch.Fixup, error) {
+	t.Helper()
+	return e.EncodeInstruction(parseInstruction(t, tt.src), testContext(tt))
+}
+
+func testContext(tt encodeTest) *arch.Context {
+	return &arch.Context{
+		PC:         tt.pc,
+		Section:    ".text",
+		MinSize:    tt.minSize,
+		DefaultRel: tt.defaultRel,
+		Bits:       tt.bits,
+		Lookup: func(name string) (arch.Symbol, bool) {
+			s, ok := testSymbols[name]
+			return s, ok
+		},
+	}
+}
+
+func parseInstruction(t *testing.T, src string) *ast.Instruction {
+	t.Helper()
+	p := parser.New(strings.NewReader(src + "\n"))
+	f := p.ParseFile()
+	if len(p.Errors) > 0 || len(f.Items) != 1 {
+		t.Fatalf("%s: parse errors %v", src, p.Errors)
+	}
+	ins, ok := f.Items[0].(*ast.Instruction)
+	if !ok {
+		t.Fatalf("%s: not an instruction", src)
+	}
+	return ins
+}
@@ -0,0 +1,291 @@
+package x86_64
+
+import "fmt"
+
+// EVEX forms follow the VEX forms of the same mnemonic, so they are only
+// chosen for zmm registers, registers 16-31 and the AVX-512 decorators.
+
+var evexTable = []tableEntry{
+	{"vbroadcastss", "xmm{k}{z}, xmm/m32", "EVEX.128.66.0F38.W0 18 /r", "AVX512VL"},
+	{"vbroadcastss", "ymm{k}{z}, xmm/m32", "EVEX.256.66.0F38.W0 18 /r", "AVX512VL"},
+	{"vbroadcastss", "zmm{k}{z}, xmm/m32", "EVEX.512.66.0F38.W0 18 /r", "AVX512F"},
+	{"vbroadcastsd", "ymm{k}{z}, xmm/m64", "EVEX.256.66.0F38.W1 19 /r", "AVX512VL"},
+	{"vbroadcastsd", "zmm{k}{z}, xmm/m64", "EVEX.512.66.0F38.W1 19 /r", "AVX512F"},
+	{"vbroadcastf32x4", "zmm{k}{z}, m128", "EVEX.512.66.0F38.W0 1A /r", "AVX512F"},
+	{"vbroadcasti32x4", "zmm{k}{z}, m128", "EVEX.512.66.0F38.W0 5A /r", "AVX512F"},
+	{"vbroadcastf64x4", "zmm{k}{z}, m256", "EVEX.512.66.0F38.W1 1B /r", "AVX512F"},
+	{"vbroadcasti64x4", "zmm{k}{z}, m256", "EVEX.512.66.0F38.W1 5B /r", "AVX512F"},
+	{"vpbroadcastd", "zmm{k}{z}, xmm/m32", "EVEX.512.66.0F38.W0 58 /r", "AVX512F"},
+	{"vpbroadcastq", "zmm{k}{z}, xmm/m64", "EVEX.512.66.0F38.W1 59 /r", "AVX512F"},
+	{"vpbroadcastb", "zmm{k}{z}, xmm/m8", "EVEX.512.66.0F38.W0 78 /r", "AVX512BW"},
+	{"vpbroadcastw", "zmm{k}{z}, xmm/m16", "EVEX.512.66.0F38.W0 79 /r", "AVX512BW"},
+	{"vpbroadcastb", "xmm{k}{z}, r32", "EVEX.128.66.0F38.W0 7A /r", "AVX512BW"},
+	{"vpbroadcastb", "ymm{k}{z}, r32", "EVEX.256.66.0F38.W0 7A /r", "AVX512BW"},
+	{"vpbroadcastb", "zmm{k}{z}, r32", "EVEX.512.66.0F38.W0 7A /r", "AVX512BW"},
+	{"vpbroadcastw", "xmm{k}{z}, r32", "EVEX.128.66.0F38.W0 7B /r", "AVX512BW"},
+	{"vpbroadcastw", "ymm{k}{z}, r32", "EVEX.256.66.0F38.W0 7B /r", "AVX512BW"},
+	{"vpbroadcastw", "zmm{k}{z}, r32", "EVEX.512.66.0F38.W0 7B /r", "AVX512BW"},
+	{"vpbroadcastd", "xmm{k}{z}, r32", "EVEX.128.66.0F38.W0 7C /r", "AVX512F"},
+	{"vpbroadcastd", "ymm{k}{z}, r32", "EVEX.256.66.0F38.W0 7C /r", "AVX512F"},
+	{"vpbroadcastd", "zmm{k}{z}, r32", "EVEX.512.66.0F38.W0 7C /r", "AVX512F"},
+	{"vpbroadcastq", "xmm{k}{z}, r64", "EVEX.128.66.0F38.W1 7C /r", "AVX512F"},
+	{"vpbroadcastq", "ymm{k}{z}, r64", "EVEX.256.66.0F38.W1 7C /r", "AVX512F"},
+	{"vpbroadcastq", "zmm{k}{z}, r64", "EVEX.512.66.0F38.W1 7C /r", "AVX512F"},
+
+	{"vpermd", "zmm{k}{z}, zmm, zmm/m512/m32bcst", "EVEX.NDS.512.66.0F38.W0 36 /r", "AVX512F"},
+	{"vpermq", "zmm{k}{z}, zmm, zmm/m512/m64bcst", "EVEX.NDS.512.66.0F38.W1 36 /r", "AVX512F"},
+	{"vpermps", "zmm{k}{z}, zmm, zmm/m512/m32bcst", "EVEX.NDS.512.66.0F38.W0 16 /r", "AVX512F"},
+	{"vpermpd", "zmm{k}{z}, zmm, zmm/m512/m64bcst", "EVEX.NDS.512.66.0F38.W1 16 /r", "AVX512F"},
+	{"vpermq", "zmm{k}{z}, zmm/m512/m64bcst, imm8", "EVEX.512.66.0F3A.W1 00 /r ib", "AVX512F"},
+	{"vpermpd", "zmm{k}{z}, zmm/m512/m64bcst, imm8", "EVEX.512.66.0F3A.W1 01 /r ib", "AVX512F"},
+	{"vpshufd", "zmm{k}{z}, zmm/m512/m32bcst, imm8", "EVEX.512.66.0F.W0 70 /r ib", "AVX512F"},
+	{"vpshufb", "zmm{k}{z}, zmm, zmm/m512", "EVEX.NDS.512.66.0F38.WIG 00 /r", "AVX512BW"},
+	{"vshufps", "zmm{k}{z}, zmm, zmm/m512/m32bcst, imm8", "EVEX.NDS.512.0F.W0 C6 /r ib", "AVX512F"},
+	{"vshufpd", "zmm{k}{z}, zmm, zmm/m512/m64bcst, imm8", "EVEX.NDS.512.66.0F.W1 C6 /r ib", "AVX512F"},
+	{"vpternlogd", "zmm{k}{z}, zmm, zmm/m512/m32bcst, imm8", "EVEX.DDS.512.66.0F3A.W0 25 /r ib", "AVX512F"},
+	{"vpternlogq", "zmm{k}{z}, zmm, zmm/m512/m64bcst, imm8", "EVEX.DDS.512.66.0F3A.W1 25 /r ib", "AVX512F"},
+
+	{"vinsertf32x4", "zmm{k}{z}, zmm, xmm/m128, imm8", "EVEX.NDS.512.66.0F3A.W0 18 /r ib", "AVX512F"},
+	{"vinserti32x4", "zmm{k}{z}, zmm, xmm/m128, imm8", "EVEX.NDS.512.66.0F3A.W0 38 /r ib", "AVX512F"},
+	{"vinsertf64x4", "zmm{k}{z}, zmm, ymm/m256, imm8", "EVEX.NDS.512.66.0F3A.W1 1A /r ib", "AVX512F"},
+	{"vinserti64x4", "zmm{k}{z}, zmm, ymm/m256, imm8", "EVEX.NDS.512.66.0F3A.W1 3A /r ib", "AVX512F"},
+	{"vextractf32x4", "xmm/m128{k}{z}, zmm, imm8", "EVEX.512.66.0F3A.W0 19 /r ib", "AVX512F"},
+	{"vextracti32x4", "xmm/m128{k}{z}, zmm, imm8", "EVEX.512.66.0F3A.W0 39 /r ib", "AVX512F"},
+	{"vextractf64x4", "ymm/m256{k}{z}, zmm, imm8", "EVEX.512.66.0F3A.W1 1B /r ib", "AVX512F"},
+	{"vextracti64x4", "ymm/m256{k}{z}, zmm, imm8", "EVEX.512.66.0F3A.W1 3B /r ib", "AVX512F"},
+
+	{"vcvtdq2ps", "zmm{k}{z}, zmm/m512/m32bcst{er}", "EVEX.512.0F.W0 5B /r", "AVX512F"},
+	{"vcvtps2dq", "zmm{k}{z}, zmm/m512/m32bcst{er}", "EVEX.512.66.0F.W0 5B /r", "AVX512F"},
+	{"vcvttps2dq", "zmm{k}{z}, zmm/m512/m32bcst{sae}", "EVEX.512.F3.0F.W0 5B /r", "AVX512F"},
+
+	{"kmovb", "k, k/m8", "VEX.L0.66.0F.W0 90 /r", "AVX512DQ"},
+	{"kmovb", "m8, k", "VEX.L0.66.0F.W0 91 /r", "AVX512DQ"},
+	{"kmovb", "k, r32", "VEX.L0.66.0F.W0 92 /r", "AVX512DQ"},
+	{"kmovb", "r32, k", "VEX.L0.66.0F.W0 93 /r", "AVX512DQ"},
+	{"kmovw", "k, k/m16", "VEX.L0.0F.W0 90 /r", "AVX512F"},
+	{"kmovw", "m16, k", "VEX.L0.0F.W0 91 /r", "AVX512F"},
+	{"kmovw", "k, r32", "VEX.L0.0F.W0 92 /r", "AVX512F"},
+	{"kmovw", "r32, k", "VEX.L0.0F.W0 93 /r", "AVX512F"},
+	{"kmovd", "k, k/m32", "VEX.L0.66.0F.W1 90 /r", "AVX512BW"},
+	{"kmovd", "m32, k", "VEX.L0.66.0F.W1 91 /r", "AVX512BW"},
+	{"kmovd", "k, r32", "VEX.L0.F2.0F.W0 92 /r", "AVX512BW"},
+	{"kmovd", "r32, k", "VEX.L0.F2.0F.W0 93 /r", "AVX512BW"},
+	{"kmovq", "k, k/m64", "VEX.L0.0F.W1 90 /r", "AVX512BW"},
+	{"kmovq", "m64, k", "VEX.L0.0F.W1 91 /r", "AVX512BW"},
+	{"kmovq", "k, r64", "VEX.L0.F2.0F.W1 92 /r", "AVX512BW"},
+	{"kmovq", "r64, k", "VEX.L0.F2.0F.W1 93 /r", "AVX512BW"},
+	{"knotw", "k, k", "VEX.L0.0F.W0 44 /r", "AVX512F"},
+	{"kortestw", "k, k", "VEX.L0.0F.W0 98 /r", "AVX512F"},
+	{"ktestw", "k, k", "VEX.L0.0F.W0 99 /r", "AVX512DQ"},
+	{"kshiftlw", "k, k, imm8", "VEX.L0.66.0F3A.W1 32 /r ib", "AVX512F"},
+	{"kshiftrw", "k, k, imm8", "VEX.L0.66.0F3A.W1 30 /r ib", "AVX512F"},
+}
+
+// evexLengths lists the EVEX vector lengths with their register names.
+// The 128- and 256-bit forms need AVX512VL.
+var evexLengths = []struct {
+	l, reg  string
+	feature string
+}{
+	{"128", "xmm", "AVX512VL"},
+	{"256", "ymm", "AVX512VL"},
+	{"512", "zmm", "AVX512F"},
+}
+
+// evexOps builds the operand pattern of a masked EVEX operation at vector
+// length l. An element size adds the broadcast form of the last operand,
+// and rounding ({er} or {sae}) is only available at 512 bits.
+func evexOps(l, reg string, nds bool, elem int, round string) string {
+	rm := fmt.Sprintf("%s/m%s", reg, l)
+	if elem != 0 {
+		rm += fmt.Sprintf("/m%dbcst", elem)
+	}
+	if l == "512" {
+		rm += round
+	}
+	if nds {
+		return reg + "{k}{z}, " + reg + ", " + rm
+	}
+	return reg + "{k}{z}, " + rm
+}
+
+// evexMoveTable generates the masked full-width moves. Stores take a mask
+// but not zeroing.
+func evexMoveTable() []tableEntry {
+	var out []tableEntry
+	for _, mv := range []struct {
+		name        string
+		pp, w       string
+		load, store byte
+		feature     string
+	}{
+		{"vmovaps", "", "W0", 0x28, 0x29, ""},
+		{"vmovups", "", "W0", 0x10, 0x11, ""},
+		{"vmovapd", "66.", "W1", 0x28, 0x29, ""},
+		{"vmovupd", "66.", "W1", 0x10, 0x11, ""},
+		{"vmovdqa32", "66.", "W0", 0x6F, 0x7F, ""},
+		{"vmovdqa64", "66.", "W1", 0x6F, 0x7F, ""},
+		{"vmovdqu32", "F3.", "W0", 0x6F, 0x7F, ""},
+		{"vmovdqu64", "F3.", "W1", 0x6F, 0x7F, ""},
+		{"vmovdqu8", "F2.", "W0", 0x6F, 0x7F, "AVX512BW"},
+		{"vmovdqu16", "F2.", "W1", 0x6F, 0x7F, "AVX512BW"},
+	} {
+		for _, l := range evexLengths {
+			feature := l.feature
+			if mv.feature != "" {
+				feature = mv.feature
+			}
+			evex := "EVEX." + l.l + "." + mv.pp + "0F." + mv.w
+			out = append(out,
+				tableEntry{mv.name, evexOps(l.l, l.reg, false, 0, ""), fmt.Sprintf("%s %02X /r", evex, mv.load), feature},
+				tableEntry{mv.name, "m" + l.l + "{k}, " + l.reg, fmt.Sprintf("%s %02X /r", evex, mv.store), feature},
+			)
+		}
+	}
+	return out
+}
+
+// evexArithTable generates the EVEX forms of the floating point
+// operations. Packed forms broadcast a single element from memory. The
+// scalar forms and the 512-bit packed forms accept embedded rounding,
+// except min and max, which only suppress exceptions, and the bitwise
+// operations, which need AVX512DQ and take neither.
+func evexArithTable() []tableEntry {
+	var out []tableEntry
+	for _, op := range sseArithOps {
+		round := "{er}"
+		switch {
+		case !op.scalar:
+			round = ""
+		case op.name == "min" || op.name == "max":
+			round = "{sae}"
+		}
+		nds := "NDS."
+		if op.name == "sqrt" {
+			nds = ""
+		}
+		for _, l := range evexLengths {
+			feature := l.feature
+			if !op.scalar {
+				feature = "AVX512DQ"
+			}
+			out = append(out,
+				tableEntry{"v" + op.name + "ps", evexOps(l.l, l.reg, nds != "", 32, round), fmt.Sprintf("EVEX.%s%s.0F.W0 %02X /r", nds, l.l, op.opcode), feature},
+				tableEntry{"v" + op.name + "pd", evexOps(l.l, l.reg, nds != "", 64, round), fmt.Sprintf("EVEX.%s%s.66.0F.W1 %02X /r", nds, l.l, op.opcode), feature},
+			)
+		}
+		if op.scalar {
+			out = append(out,
+				tableEntry{"v" + op.name + "ss", "xmm{k}{z}, xmm, xmm/m32" + round, fmt.Sprintf("EVEX.NDS.LIG.F3.0F.W0 %02X /r", op.opcode), "AVX512F"},
+				tableEntry{"v" + op.name + "sd", "xmm{k}{z}, xmm, xmm/m64" + round, fmt.Sprintf("EVEX.NDS.LIG.F2.0F.W1 %02X /r", op.opcode), "AVX512F"},
+			)
+		}
+	}
+	return out
+}
+
+// evexIntTable generates the EVEX integer operations. Doubleword and
+// quadword forms broadcast from memory; byte and word forms need
+// AVX512BW and do not.
+func evexIntTable() []tableEntry {
+	var out []tableEntry
+	for _, op := range []struct {
+		name    string
+		enc     string
+		opcode  byte
+		elem    int
+		feature string
+	}{
+		{"vpaddb", "0F.WIG", 0xFC, 0, "AVX512BW"},
+		{"vpaddw", "0F.WIG", 0xFD, 0, "AVX512BW"},
+		{"vpaddd", "0F.W0", 0xFE, 32, ""},
+		{"vpaddq", "0F.W1", 0xD4, 64, ""},
+		{"vpsubb", "0F.WIG", 0xF8, 0, "AVX512BW"},
+		{"vpsubw", "0F.WIG", 0xF9, 0, "AVX512BW"},
+		{"vpsubd", "0F.W0", 0xFA, 32, ""},
+		{"vpsubq", "0F.W1", 0xFB, 64, ""},
+		{"vpmullw", "0F.WIG", 0xD5, 0, "AVX512BW"},
+		{"vpmulld", "0F38.W0", 0x40, 32, ""},
+		{"vpmullq", "0F38.W1", 0x40, 64, "AVX512DQ"},
+		{"vpandd", "0F.W0", 0xDB, 32, ""},
+		{"vpandq", "0F.W1", 0xDB, 64, ""},
+		{"vpandnd", "0F.W0", 0xDF, 32, ""},
+		{"vpandnq", "0F.W1", 0xDF, 64, ""},
+		{"vpord", "0F.W0", 0xEB, 32, ""},
+		{"vporq", "0F.W1", 0xEB, 64, ""},
+		{"vpxord", "0F.W0", 0xEF, 32, ""},
+		{"vpxorq", "0F.W1", 0xEF, 64, ""},
+		{"vpminsd", "0F38.W0", 0x39, 32, ""},
+		{"vpminsq", "0F38.W1", 0x39, 64, ""},
+		{"vpmaxsd", "0F38.W0", 0x3D, 32, ""},
+		{"vpmaxsq", "0F38.W1", 0x3D, 64, ""},
+	} {
+		for _, l := range evexLengths {
+			feature := l.feature
+			if op.feature != "" {
+				feature = op.feature
+			}
+			out = append(out, tableEntry{op.name, evexOps(l.l, l.reg, true, op.elem, ""), fmt.Sprintf("EVEX.NDS.%s.66.%s %02X /r", l.l, op.enc, op.opcode), feature})
+		}
+	}
+
+	// Comparisons write a mask register, which may itself be masked.
+	for _, op := range []struct {
+		name    string
+		enc     string
+		opcode  byte
+		elem    int
+		feature string
+	}{
+		{"vpcmpeqb", "0F.WIG", 0x74, 0, "AVX512BW"},
+		{"vpcmpeqw", "0F.WIG", 0x75, 0, "AVX512BW"},
+		{"vpcmpeqd", "0F.W0", 0x76, 32, ""},
+		{"vpcmpeqq", "0F38.W1", 0x29, 64, ""},
+		{"vpcmpgtd", "0F.W0", 0x66, 32, ""},
+		{"vpcmpgtq", "0F38.W1", 0x37, 64, ""},
+		{"vptestmd", "0F38.W0", 0x27, 32, ""},
+		{"vptestmq", "0F38.W1", 0x27, 64, ""},
+	} {
+		for _, l := range evexLengths {
+			feature := l.feature
+			if op.feature != "" {
+				feature = op.feature
+			}
+			rm := fmt.Sprintf("%s/m%s", l.reg, l.l)
+			if op.elem != 0 {
+				rm += fmt.Sprintf("/m%dbcst", op.elem)
+			}
+			out = append(out, tableEntry{op.name, "k{k}, " + l.reg + ", " + rm, fmt.Sprintf("EVEX.NDS.%s.66.%s %02X /r", l.l, op.enc, op.opcode), feature})
+		}
+	}
+	return out
+}
+
+// evexFmaTable generates the EVEX fused multiply-add forms, using the
+// same opcode layout as fmaTable.
+func evexFmaTable() []tableEntry {
+	var out []tableEntry
+	for _, op := range []struct {
+		name   string
+		offset byte
+	}{{"fmadd", 0x8}, {"fmsub", 0xA}, {"fnmadd", 0xC}, {"fnmsub", 0xE}} {
+		for _, order := range []struct {
+			suffix string
+			row    byte
+		}{{"132", 0x90}, {"213", 0xA0}, {"231", 0xB0}} {
+			opcode := order.row + op.offset
+			name := "v" + op.name + order.suffix
+			for _, l := range evexLengths {
+				out = append(out,
+					tableEntry{name + "ps", evexOps(l.l, l.reg, true, 32, "{er}"), fmt.Sprintf("EVEX.DDS.%s.66.0F38.W0 %02X /r", l.l, opcode), l.feature},
+					tableEntry{name + "pd", evexOps(l.l, l.reg, true, 64, "{er}"), fmt.Sprintf("EVEX.DDS.%s.66.0F38.W1 %02X /r", l.l, opcode), l.feature},
+				)
+			}
+			out = append(out,
+				tableEntry{name + "ss", "xmm{k}{z}, xmm, xmm/m32{er}", fmt.Sprintf("EVEX.DDS.LIG.66.0F38.W0 %02X /r", opcode+1), "AVX512F"},
+				tableEntry{name + "sd", "xmm{k}{z}, xmm, xmm/m64{er}", fmt.Sprintf("EVEX.DDS.LIG.66.0F38.W1 %02X /r", opcode+1), "AVX512F"},
+			)
+		}
+	}
+	return out
+}
@@ -0,0 +1,348 @@
+package x86_64
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type operandKind int
+
+const (
+	kindReg operandKind = iota
+	kindRM
+	kindMem
+	kindImm
+	kindSImm
+	kindUImm
+	kindRel
+	kindFar
+	kindFixed
+	kindOne
+)
+
+type operandSpec struct {
+	kind  operandKind
+	class regClass
+	size  int
+	reg   string
+	mask  bool
+	zero  bool
+	bcst  int
+}
+
+func (s operandSpec) isImm() bool {
+	return s.kind == kindImm || s.kind == kindSImm || s.kind == kindUImm
+}
+
+func (s operandSpec) isGPR() bool {
+	return (s.kind == kindReg || s.kind == kindRM || s.kind == kindFixed) && s.class == classGPR
+}
+
+func (s operandSpec) matchesReg(r register) bool {
+	return r.class == s.class && (s.class != classGPR || r.size == s.size)
+}
+
+// form is one encoding of an instruction: an operand pattern such as
+// "r/m64, imm32" together with the bytes it assembles to.
+type form struct {
+	mnemonic string
+	operands []operandSpec
+	prefixes []byte
+	opcode   []byte
+	digit    int
+	modrm    bool
+	mr       bool
+	plusReg  bool
+	rexW     bool
+	o16      bool
+	o32      bool
+	addrSize int
+	d64      bool
+	i64      bool
+	o64      bool
+	wait     bool
+	rep      bool
+	repe     bool
+	lock     bool
+	imms     []int
+	rel      int
+	features []string
+	opSize   int
+
+	vex    bool
+	evex   bool
+	vexL   byte
+	vexPP  byte
+	vexMap byte
+	vexW   bool
+	vvvv   int
+	er     bool
+	sae    bool
+}
+
+func parseOperandSpec(s string) (operandSpec, error) {
+	sizes := map[string]int{"8": 1, "16": 2, "32": 4, "64": 8, "80": 10, "128": 16, "256": 32, "512": 64}
+	classes := map[string]regClass{"xmm": classXMM, "ymm": classYMM, "zmm": classZMM, "k": classK, "st": classST, "cr": classCR, "dr": classDR}
+	if class, ok := classes[s]; ok {
+		return operandSpec{kind: kindReg, class: class, size: registers[s+"0"].size}, nil
+	}
+	if name, m, ok := strings.Cut(s, "/m"); ok && classes[name] != classGPR {
+		if n, ok := sizes[m]; ok {
+			return operandSpec{kind: kindRM, class: classes[name], size: n}, nil
+		}
+	}
+	switch {
+	case s == "":
+		return operandSpec{}, fmt.Errorf("empty operand type")
+	case s == "1":
+		return operandSpec{kind: kindOne}, nil
+	case s == "sreg":
+		return operandSpec{kind: kindReg, class: classSeg, size: 2}, nil
+	case s == "m":
+		return operandSpec{kind: kindMem}, nil
+	case strings.HasPrefix(s, "simm"):
+		if n, ok := sizes[s[4:]]; ok {
+			return operandSpec{kind: kindSImm, size: n}, nil
+		}
+	case strings.HasPrefix(s, "uimm"):
+		if n, ok := sizes[s[4:]]; ok {
+			return operandSpec{kind: kindUImm, size: n}, nil
+		}
+	case strings.HasPrefix(s, "r/m"):
+		if n, ok := sizes[s[3:]]; ok {
+			return operandSpec{kind: kindRM, size: n}, nil
+		}
+	case strings.HasPrefix(s, "imm"):
+		if n, ok := sizes[s[3:]]; ok {
+			return operandSpec{kind: kindImm, size: n}, nil
+		}
+	case strings.HasPrefix(s, "ptr16:"):
+		if n, ok := sizes[s[6:]]; ok {
+			return operandSpec{kind: kindFar, size: n}, nil
+		}
+	case strings.HasPrefix(s, "rel"):
+		if n, ok := sizes[s[3:]]; ok {
+			return operandSpec{kind: kindRel, size: n}, nil
+		}
+	case s[0] == 'm':
+		if n, ok := sizes[s[1:]]; ok {
+			return operandSpec{kind: kindMem, size: n}, nil
+		}
+	case s[0] == 'r':
+		if n, ok := sizes[s[1:]]; ok {
+			return operandSpec{kind: kindReg, size: n}, nil
+		}
+	}
+	if r, ok := registers[s]; ok {
+		return operandSpec{kind: kindFixed, class: r.class, size: r.size, reg: r.name}, nil
+	}
+	return operandSpec{}, fmt.Errorf("unknown operand type %q", s)
+}
+
+func parseForm(mnemonic, operands, encoding, feature string) (*form, error) {
+	f := &form{mnemonic: mnemonic, digit: -1, vvvv: -1}
+	if feature != "" {
+		f.features = strings.Split(feature, ",")
+	}
+
+	if operands != "" {
+		for _, s := range strings.Split(operands, ",") {
+			s, decorators, _ := strings.Cut(strings.TrimSpace(s), "{")
+			bcst := 0
+			if i := strings.LastIndex(s, "/m"); i >= 0 && strings.HasSuffix(s, "bcst") {
+				n, err := strconv.Atoi(s[i+2 : len(s)-4])
+				if err != nil {
+					return nil, fmt.Errorf("bad broadcast in %q", s)
+				}
+				s, bcst = s[:i], n/8
+			}
+			spec, err := parseOperandSpec(s)
+			if err != nil {
+				return nil, err
+			}
+			spec.bcst = bcst
+			if decorators != "" {
+				for _, d := range strings.Split(strings.TrimSuffix(decorators, "}"), "}{") {
+					switch d {
+					case "k":
+						spec.mask = true
+					case "z":
+						spec.zero = true
+					case "er":
+						f.er = true
+					case "sae":
+						f.sae = true
+					default:
+						return nil, fmt.Errorf("unknown decorator {%s}", d)
+					}
+				}
+			}
+			f.operands = append(f.operands, spec)
+			if f.opSize == 0 && !spec.isImm() && spec.kind != kindRel {
+				f.opSize = spec.size
+			}
+		}
+	}
+
+	for _, tok := range strings.Fields(encoding) {
+		switch {
+		case strings.HasPrefix(tok, "VEX."), strings.HasPrefix(tok, "EVEX."):
+			if err := f.parseVex(tok); err != nil {
+				return nil, err
+			}
+		case tok == "REX.W":
+			f.rexW = true
+		case tok == "o16":
+			f.o16 = true
+		case tok == "o32":
+			f.o32 = true
+		case tok == "a16":
+			f.addrSize = 2
+		case tok == "a32":
+			f.addrSize = 4
+		case tok == "a64":
+			f.addrSize = 8
+		case tok == "MR":
+			f.mr = true
+		case tok == "RMV":
+			f.vvvv = 2
+		case tok == "d64":
+			f.d64 = true
+		case tok == "i64":
+			f.i64 = true
+		case tok == "o64":
+			f.o64 = true
+		case tok == "wait":
+			f.wait = true
+		case tok == "rep":
+			f.rep = true
+		case tok == "repe":
+			f.repe = true
+		case tok == "lock":
+			f.lock = true
+		case tok == "/r":
+			f.modrm = true
+		case len(tok) == 2 && tok[0] == '/' && tok[1] >= '0' && tok[1] <= '7':
+			f.modrm = true
+			f.digit = int(tok[1] - '0')
+		case tok == "ib" || tok == "iw" || tok == "id" || tok == "io":
+			f.imms = append(f.imms, map[string]int{"ib": 1, "iw": 2, "id": 4, "io": 8}[tok])
+		case tok == "cb" || tok == "cd":
+			f.rel = map[string]int{"cb": 1, "cd": 4}[tok]
+		default:
+			hex, plus := strings.CutSuffix(tok, "+r")
+			b, err := strconv.ParseUint(hex, 16, 8)
+			if err != nil || len(hex) != 2 {
+				return nil, fmt.Errorf("bad encoding token %q", tok)
+			}
+			if f.opcode == nil && !plus && !f.vex && (b == 0x66 || b == 0xF2 || b == 0xF3) {
+				f.prefixes = append(f.prefixes, byte(b))
+				continue
+			}
+			f.opcode = append(f.opcode, byte(b))
+			f.plusReg = plus
+		}
+	}
+	if f.opcode == nil {
+		return nil, fmt.Errorf("no opcode in %q", encoding)
+	}
+	f.o32 = f.o32 || f.gprSize() == 4
+	return f, nil
+}
+
+// gprSize returns the operand size of a legacy form whose size comes from
+// its general register operands, or 0 for forms that take their size from
+// a prefix or have none. It decides whether the form needs 66 in 16-bit
+// mode.
+func (f *form) gprSize() int {
+	if f.vex || f.o16 || f.rexW {
+		return 0
+	}
+	for _, s := range f.operands {
+		if (s.kind == kindReg || s.kind == kindRM) && s.class != classGPR && s.class != classSeg {
+			return 0
+		}
+	}
+	for _, s := range f.operands {
+		if s.isImm() || s.kind == kindRel || s.kind == kindFar {
+			continue
+		}
+		if s.isGPR() {
+			return s.size
+		}
+		return 0
+	}
+	return 0
+}
+
+// validIn reports whether the form can be encoded in the given mode. Forms
+// marked i64 do not exist in 64-bit mode, while REX.W, o64 and 64-bit
+// general registers exist only there.
+func (f *form) validIn(mode int) bool {
+	if mode == 64 {
+		return !f.i64
+	}
+	if f.rexW || f.o64 || f.addrSize == 8 {
+		return false
+	}
+	for _, s := range f.operands {
+		if s.isGPR() && s.size == 8 {
+			return false
+		}
+	}
+	return true
+}
+
+// shortImm reports whether the form is an optimized encoding that only
+// exists to shorten a constant immediate.
+func (f *form) shortImm() bool {
+	for _, s := range f.operands {
+		if s.kind == kindSImm || s.kind == kindUImm {
+			return true
+		}
+	}
+	return false
+}
+
+// parseVex reads a VEX or EVEX prefix description in SDM notation, such
+// as VEX.NDS.256.66.0F38.W0. NDS and DDS take the second operand from
+// VEX.vvvv, NDD the first. EVEX forms set vex as well, sharing the fields.
+func (f *form) parseVex(tok string) error {
+	f.vex = true
+	f.evex = strings.HasPrefix(tok, "EVEX.")
+	f.vexMap = 1
+	for _, field := range strings.Split(tok, ".")[1:] {
+		switch field {
+		case "NDS", "DDS":
+			f.vvvv = 1
+		case "NDD":
+			f.vvvv = 0
+		case "128", "LIG", "LZ", "L0":
+			f.vexL = 0
+		case "256", "L1":
+			f.vexL = 1
+		case "512":
+			f.vexL = 2
+		case "66":
+			f.vexPP = 1
+		case "F3":
+			f.vexPP = 2
+		case "F2":
+			f.vexPP = 3
+		case "0F":
+			f.vexMap = 1
+		case "0F38":
+			f.vexMap = 2
+		case "0F3A":
+			f.vexMap = 3
+		case "W0", "WIG":
+			f.vexW = false
+		case "W1":
+			f.vexW = true
+		default:
+			return fmt.Errorf("bad VEX field %q in %s", field, tok)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,551 @@
+package x86_64
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gasm/internal/arch"
+	"gasm/internal/ast"
+	"math"
+)
+
+const noReg = -1
+
+type memRef struct {
+	base  int
+	index int
+	scale int
+	disp  int64
+	sym   string
+	rip   bool
+	seg   byte
+
+	// addrSize is the width of the address registers in bytes, or the
+	// mode's default for an absolute address.
+	addrSize int
+
+	// dispScale is the N of EVEX disp8*N compression: a one-byte
+	// displacement is scaled by the memory operand size.
+	dispScale int
+}
+
+func (m memRef) disp8() (int64, bool) {
+	d := m.disp
+	if m.dispScale > 1 {
+		if d%int64(m.dispScale) != 0 {
+			return 0, false
+		}
+		d /= int64(m.dispScale)
+	}
+	return d, fitsInt8(d)
+}
+
+func (e *Encoder) resolveMem(mem ast.MemOperand, defaultRel bool, mode int) (memRef, error) {
+	m := memRef{base: noReg, index: noReg, scale: 1}
+	addrSize := 0
+
+	checkAddrReg := func(r register) error {
+		valid := r.size == 4 || r.size == 8 && mode == 64 || r.size == 2 && mode != 64
+		if r.class != classGPR || !valid {
+			return fmt.Errorf("%s cannot be used in an address in %d-bit mode", r.name, mode)
+		}
+		if addrSize != 0 && addrSize != r.size {
+			return fmt.Errorf("mixed address sizes in effective address")
+		}
+		addrSize = r.size
+		return nil
+	}
+
+	if mem.Base != "" {
+		r, err := e.modeReg(mem.Base, mode)
+		if err != nil {
+			return m, err
+		}
+		if err := checkAddrReg(r); err != nil {
+			return m, err
+		}
+		m.base = r.num
+	}
+
+	if mem.Index != "" {
+		r, err := e.modeReg(mem.Index, mode)
+		if err != nil {
+			return m, err
+		}
+		if err := checkAddrReg(r); err != nil {
+			return m, err
+		}
+		if r.num == 4 {
+			return m, fmt.Errorf("%s cannot be used as an index register", mem.Index)
+		}
+		m.index = r.num
+		switch mem.Scale {
+		case 0, 1:
+			m.scale = 1
+		case 2, 4, 8:
+			m.scale = mem.Scale
+		default:
+			return m, fmt.Errorf("invalid scale %d: must be 1, 2, 4 or 8", mem.Scale)
+		}
+	} else if mem.Scale > 1 {
+		return m, fmt.Errorf("scale %d given without an index register", mem.Scale)
+	}
+	if addrSize == 0 {
+		addrSize = mode / 8
+	}
+	m.addrSize = addrSize
+	if _, ok := rm16(m); addrSize == 2 && (!ok || m.scale != 1) {
+		return m, fmt.Errorf("invalid 16-bit effective address: only bx or bp plus si or di can be used")
+	}
+	if mem.Seg != "" {
+		m.seg = segmentPrefixes[mem.Seg]
+	}
+
+	if mem.Rel {
+		if mode != 64 {
+			return m, fmt.Errorf("rip-relative addressing needs 64-bit mode")
+		}
+		if m.base != noReg || m.index != noReg {
+			return m, fmt.Errorf("rip-relative address cannot use base or index registers")
+		}
+		m.rip = true
+	}
+
+	if mem.Disp != nil {
+		v, err := evalValue(mem.Disp)
+		if err != nil {
+			return m, err
+		}
+		switch {
+		case addrSize == 2 && !immFits(v.addend, 2):
+			return m, fmt.Errorf("displacement %d does not fit in 16 bits", v.addend)
+		case mode == 64 && !fitsInt32(v.addend), mode != 64 && !immFits(v.addend, 4):
+			return m, fmt.Errorf("displacement %d does not fit in 32 bits", v.addend)
+		}
+		m.disp, m.sym = v.addend, v.sym
+	}
+	// As in NASM, fs and gs overrides are absolute even under default rel,
+	// since their base is a thread or CPU block rather than the image.
+	if defaultRel && mode == 64 && !mem.Abs && m.seg != 0x64 && m.seg != 0x65 && m.base == noReg && m.index == noReg && m.sym != "" {
+		m.rip = true
+	}
+
+	return m, nil
+}
+
+func writeMem(buf *output, regField int, m memRef) {
+	reg := byte(regField&7) << 3
+
+	if m.rip {
+		buf.WriteByte(reg | 0x05)
+		if m.sym != "" {
+			buf.fixup(4, arch.RelocRel32, m.sym, m.disp)
+			writeImm32(buf, 0)
+		} else {
+			writeImm32(buf, m.disp)
+		}
+		return
+	}
+	if m.addrSize == 2 {
+		writeMem16(buf, reg, m)
+		return
+	}
+	if m.base == noReg && m.index == noReg && m.addrSize == 4 {
+		buf.WriteByte(reg | 0x05)
+		writeDisp32(buf, m)
+		return
+	}
+
+	if m.base == noReg {
+		buf.WriteByte(reg | 0x04)
+		if m.index == noReg {
+			buf.WriteByte(0x25)
+		} else {
+			buf.WriteByte(scaleBits(m.scale)<<6 | byte(m.index&7)<<3 | 0x05)
+		}
+		writeDisp32(buf, m)
+		return
+	}
+
+	var mod byte
+	disp8, short := m.disp8()
+	switch {
+	case m.sym != "":
+		mod = 0x80
+	case m.disp == 0 && m.base&7 != 5:
+		mod = 0x00
+	case short:
+		mod = 0x40
+	default:
+		mod = 0x80
+	}
+
+	if m.index == noReg && m.base&7 != 4 {
+		buf.WriteByte(mod | reg | byte(m.base&7))
+	} else {
+		index := byte(4)
+		if m.index != noReg {
+			index = byte(m.index & 7)
+		}
+		buf.WriteByte(mod | reg | 0x04)
+		buf.WriteByte(scaleBits(m.scale)<<6 | index<<3 | byte(m.base&7))
+	}
+
+	switch mod {
+	case 0x40:
+		buf.WriteByte(byte(int8(disp8)))
+	case 0x80:
+		writeDisp32(buf, m)
+	}
+}
+
+// rm16 returns the ModRM.rm field of a 16-bit address, which can only
+// combine bx or bp with si or di. An address without registers gives the
+// rm of the bare disp16 form.
+func rm16(m memRef) (byte, bool) {
+	base, index := noReg, noReg
+	for _, r := range []int{m.base, m.index} {
+		switch {
+		case r == noReg:
+		case (r == 3 || r == 5) && base == noReg:
+			base = r
+		case (r == 6 || r == 7) && index == noReg:
+			index = r
+		default:
+			return 0, false
+		}
+	}
+	switch {
+	case base == noReg && index == noReg:
+		return 6, true
+	case base == noReg:
+		return byte(index - 2), true
+	case index == noReg && base == 3:
+		return 7, true
+	case index == noReg:
+		return 6, true
+	case base == 3:
+		return byte(index - 6), true
+	default:
+		return byte(index - 4), true
+	}
+}
+
+func writeMem16(buf *output, reg byte, m memRef) {
+	rm, _ := rm16(m)
+	if m.base == noReg && m.index == noReg {
+		buf.WriteByte(reg | rm)
+		writeDisp16(buf, m)
+		return
+	}
+
+	var mod byte
+	disp8, short := m.disp8()
+	switch {
+	case m.sym != "":
+		mod = 0x80
+	case m.disp == 0 && rm != 6:
+		mod = 0x00
+	case short:
+		mod = 0x40
+	default:
+		mod = 0x80
+	}
+	buf.WriteByte(mod | reg | rm)
+	switch mod {
+	case 0x40:
+		buf.WriteByte(byte(int8(disp8)))
+	case 0x80:
+		writeDisp16(buf, m)
+	}
+}
+
+func writeDisp16(buf *output, m memRef) {
+	if m.sym != "" {
+		buf.fixup(2, arch.RelocAbs16, m.sym, m.disp)
+		writeImm(buf, 0, 2)
+		return
+	}
+	writeImm(buf, m.disp, 2)
+}
+
+func writeDisp32(buf *output, m memRef) {
+	if m.sym != "" {
+		buf.fixup(4, arch.RelocAbs32, m.sym, m.disp)
+		writeImm32(buf, 0)
+		return
+	}
+	writeImm32(buf, m.disp)
+}
+
+func scaleBits(scale int) byte {
+	switch scale {
+	case 2:
+		return 1
+	case 4:
+		return 2
+	case 8:
+		return 3
+	default:
+		return 0
+	}
+}
+
+func writeRex(buf *output, w bool, reg, rm register, index int) error {
+	var rex byte = 0x40
+	if w {
+		rex |= 0x08
+	}
+	if reg.num >= 8 {
+		rex |= 0x04
+	}
+	if index >= 8 {
+		rex |= 0x02
+	}
+	if rm.num >= 8 {
+		rex |= 0x01
+	}
+	if rex == 0x40 && !reg.rex && !rm.rex {
+		return nil
+	}
+	for _, r := range []register{reg, rm} {
+		if r.high {
+			return fmt.Errorf("%s cannot be encoded in an instruction requiring a REX prefix", r.name)
+		}
+	}
+	buf.WriteByte(rex)
+	return nil
+}
+
+// writeVex emits the two-byte C5 form when the instruction needs no
+// X, B or W bits and lives in the 0F map, and the three-byte C4 form
+// otherwise. The R, X, B and vvvv fields are stored inverted.
+func writeVex(buf *output, f *form, reg, rm register, index int, vreg register) error {
+	for _, r := range []register{reg, rm} {
+		if r.high {
+			return fmt.Errorf("%s cannot be encoded in a VEX instruction", r.name)
+		}
+	}
+	r := byte(^reg.num>>3) & 1
+	x := byte(^index>>3) & 1
+	b := byte(^rm.num>>3) & 1
+	vvvv := byte(^vreg.num) & 0x0F
+	lpp := f.vexL<<2 | f.vexPP
+
+	if f.vexMap == 1 && x == 1 && b == 1 && !f.vexW {
+		buf.WriteByte(0xC5)
+		buf.WriteByte(r<<7 | vvvv<<3 | lpp)
+		return nil
+	}
+	var w byte
+	if f.vexW {
+		w = 1
+	}
+	buf.WriteByte(0xC4)
+	buf.WriteByte(r<<7 | x<<6 | b<<5 | f.vexMap)
+	buf.WriteByte(w<<7 | vvvv<<3 | lpp)
+	return nil
+}
+
+var roundingModes = map[string]byte{"rn-sae": 0, "rd-sae": 1, "ru-sae": 2, "rz-sae": 3, "sae": 0}
+
+// writeEvex emits the four-byte EVEX prefix. Registers 16-31 take a fifth
+// bit from R', V' and, for a register in ModRM.rm, X. Embedded rounding
+// reuses the vector length field, and the b bit marks both broadcast and
+// rounding.
+func writeEvex(buf *output, f *form, reg, rm register, index int, vreg register, args []arg) {
+	r := byte(^reg.num>>3) & 1
+	r2 := byte(^reg.num>>4) & 1
+	x := byte(^(index>>3 | rm.num>>4)) & 1
+	b := byte(^rm.num>>3) & 1
+	vvvv := byte(^vreg.num) & 0x0F
+	v2 := byte(^vreg.num>>4) & 1
+	var w, z, bit, aaa byte
+	if f.vexW {
+		w = 1
+	}
+	ll := f.vexL
+	for _, a := range args {
+		if a.mask != 0 {
+			aaa = byte(a.mask)
+		}
+		if a.zero {
+			z = 1
+		}
+		if a.bcst != 0 {
+			bit = 1
+		}
+		if a.kind == argRound {
+			bit, ll = 1, roundingModes[a.round]
+		}
+	}
+	buf.WriteByte(0x62)
+	buf.WriteByte(r<<7 | x<<6 | b<<5 | r2<<4 | f.vexMap)
+	buf.WriteByte(w<<7 | vvvv<<3 | 0x04 | f.vexPP)
+	buf.WriteByte(z<<7 | ll<<5 | bit<<4 | v2<<3 | aaa)
+}
+
+func (e *Encoder) writeModRM(buf *output, regField, rmField int, base byte) {
+	modrm := base | byte((regField&7)<<3) | byte(rmField&7)
+	buf.WriteByte(modrm)
+}
+
+func writeImm(buf *output, v int64, size int) {
+	switch size {
+	case 1:
+		buf.WriteByte(byte(v))
+	case 2:
+		var tmp [2]byte
+		binary.LittleEndian.PutUint16(tmp[:], uint16(v))
+		buf.Write(tmp[:])
+	case 8:
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], uint64(v))
+		buf.Write(tmp[:])
+	default:
+		writeImm32(buf, v)
+	}
+}
+
+func writeImm32(buf *output, v int64) {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], uint32(v))
+	buf.Write(tmp[:])
+}
+
+func fitsInt32(v int64) bool {
+	return v >= math.MinInt32 && v <= math.MaxInt32
+}
+
+func fitsInt8(v int64) bool {
+	return v >= math.MinInt8 && v <= math.MaxInt8
+}
+
+func immFits(v int64, size int) bool {
+	switch size {
+	case 1:
+		return v >= math.MinInt8 && v <= math.MaxUint8
+	case 2:
+		return v >= math.MinInt16 && v <= math.MaxUint16
+	case 4:
+		return v >= math.MinInt32 && v <= math.MaxUint32
+	default:
+		return true
+	}
+}
+
+func signExtend(v int64, size int) int64 {
+	switch size {
+	case 1:
+		return int64(int8(v))
+	case 2:
+		return int64(int16(v))
+	case 4:
+		return int64(int32(v))
+	default:
+		return v
+	}
+}
+
+type value struct {
+	sym    string
+	addend int64
+}
+
+func evalValue(ex ast.Expr) (value, error) {
+	switch v := ex.(type) {
+	case ast.IdentExpr:
+		return value{sym: v.Name}, nil
+	case ast.BinaryExpr:
+		if v.Op != "+" && v.Op != "-" {
+			break
+		}
+		l, err := evalValue(v.Left)
+		if err != nil {
+			return value{}, err
+		}
+		r, err := evalValue(v.Right)
+		if err != nil {
+			return value{}, err
+		}
+		if v.Op == "-" {
+			if r.sym != "" {
+				return value{}, fmt.Errorf("cannot subtract symbol %s", r.sym)
+			}
+			return value{sym: l.sym, addend: l.addend - r.addend}, nil
+		}
+		if l.sym != "" && r.sym != "" {
+			return value{}, fmt.Errorf("cannot add symbols %s and %s", l.sym, r.sym)
+		}
+		if r.sym != "" {
+			l.sym = r.sym
+		}
+		return value{sym: l.sym, addend: l.addend + r.addend}, nil
+	}
+	c, err := evalConst(ex)
+	if err != nil {
+		return value{}, err
+	}
+	return value{addend: c}, nil
+}
+
+func writeValue(buf *output, v value, size int) error {
+	if v.sym == "" {
+		writeImm(buf, v.addend, size)
+		return nil
+	}
+	switch size {
+	case 8:
+		buf.fixup(8, arch.RelocAbs64, v.sym, v.addend)
+	case 4:
+		buf.fixup(4, arch.RelocAbs32, v.sym, v.addend)
+	case 2:
+		buf.fixup(2, arch.RelocAbs16, v.sym, v.addend)
+	default:
+		return fmt.Errorf("symbol %s cannot be used in a %d-bit immediate", v.sym, size*8)
+	}
+	writeImm(buf, 0, size)
+	return nil
+}
+
+func evalConst(ex ast.Expr) (int64, error) {
+	switch v := ex.(type) {
+	case ast.NumberExpr:
+		return v.Val, nil
+	case ast.UnaryExpr:
+		x, err := evalConst(v.X)
+		if err != nil {
+			return 0, err
+		}
+		if v.Op == "-" {
+			return -x, nil
+		}
+		return x, nil
+	case ast.BinaryExpr:
+		l, err := evalConst(v.Left)
+		if err != nil {
+			return 0, err
+		}
+		r, err := evalConst(v.Right)
+		if err != nil {
+			return 0, err
+		}
+		switch v.Op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			if r == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return l / r, nil
+		}
+		return 0, fmt.Errorf("unsupported operator %q", v.Op)
+	case ast.IdentExpr:
+		return 0, fmt.Errorf("symbol %s is not a constant", v.Name)
+	default:
+		return 0, fmt.Errorf("unsupported expression %T", ex)
+	}
+}
@@ -0,0 +1,132 @@
+package x86_64
+
+import (
+	"fmt"
+	"strings"
+)
+
+type regClass int
+
+const (
+	classGPR regClass = iota
+	classXMM
+	classYMM
+	classZMM
+	classK
+	classST
+	classCR
+	classDR
+	classSeg
+)
+
+type register struct {
+	name  string
+	class regClass
+	num   int
+	size  int
+	high  bool
+	rex   bool
+}
+
+var registers = buildRegisters()
+
+// segmentRegisters are in encoding order; segmentPrefixes holds their
+// override prefix bytes.
+var segmentRegisters = []string{"es", "cs", "ss", "ds", "fs", "gs"}
+
+var segmentPrefixes = map[string]byte{
+	"es": 0x26, "cs": 0x2E, "ss": 0x36, "ds": 0x3E, "fs": 0x64, "gs": 0x65,
+}
+
+func buildRegisters() map[string]register {
+	regs := make(map[string]register)
+	add := func(r register) { regs[r.name] = r }
+
+	legacy := []struct{ q, d, w, b string }{
+		{"rax", "eax", "ax", "al"},
+		{"rcx", "ecx", "cx", "cl"},
+		{"rdx", "edx", "dx", "dl"},
+		{"rbx", "ebx", "bx", "bl"},
+		{"rsp", "esp", "sp", "spl"},
+		{"rbp", "ebp", "bp", "bpl"},
+		{"rsi", "esi", "si", "sil"},
+		{"rdi", "edi", "di", "dil"},
+	}
+	for i, n := range legacy {
+		add(register{name: n.q, num: i, size: 8})
+		add(register{name: n.d, num: i, size: 4})
+		add(register{name: n.w, num: i, size: 2})
+		add(register{name: n.b, num: i, size: 1, rex: i >= 4})
+	}
+	for i, n := range []string{"ah", "ch", "dh", "bh"} {
+		add(register{name: n, num: i + 4, size: 1, high: true})
+	}
+	for i := 8; i < 16; i++ {
+		n := fmt.Sprintf("r%d", i)
+		add(register{name: n, num: i, size: 8})
+		add(register{name: n + "d", num: i, size: 4})
+		add(register{name: n + "w", num: i, size: 2})
+		add(register{name: n + "b", num: i, size: 1})
+	}
+	for i := 0; i < 32; i++ {
+		add(register{name: fmt.Sprintf("xmm%d", i), class: classXMM, num: i, size: 16})
+		add(register{name: fmt.Sprintf("ymm%d", i), class: classYMM, num: i, size: 32})
+		add(register{name: fmt.Sprintf("zmm%d", i), class: classZMM, num: i, size: 64})
+	}
+	for i := 0; i < 8; i++ {
+		add(register{name: fmt.Sprintf("k%d", i), class: classK, num: i, size: 8})
+		add(register{name: fmt.Sprintf("st%d", i), class: classST, num: i, size: 10})
+		add(register{name: fmt.Sprintf("dr%d", i), class: classDR, num: i, size: 8})
+	}
+	for _, i := range []int{0, 2, 3, 4, 8} {
+		add(register{name: fmt.Sprintf("cr%d", i), class: classCR, num: i, size: 8})
+	}
+	for i, n := range segmentRegisters {
+		add(register{name: n, class: classSeg, num: i, size: 2})
+	}
+	return regs
+}
+
+// registerNumbers returns the encoder's register set. For 32-bit x86 it
+// leaves out the registers that exist only in 64-bit mode.
+func registerNumbers(legacyOnly bool) map[string]int {
+	out := make(map[string]int, len(registers))
+	for name, r := range registers {
+		if legacyOnly && !r.legacy() {
+			continue
+		}
+		out[name] = r.num
+	}
+	return out
+}
+
+// legacy reports whether r can be encoded outside 64-bit mode, that is
+// without a REX prefix.
+func (r register) legacy() bool {
+	return !r.rex && r.num < 8 && (r.class != classGPR || r.size != 8)
+}
+
+func (e *Encoder) reg(name string) (register, error) {
+	r, ok := registers[strings.ToLower(name)]
+	if !ok {
+		return register{}, fmt.Errorf("unknown register: %s", name)
+	}
+	return r, nil
+}
+
+// modeReg looks up a register and rejects it outside 64-bit mode if it
+// needs a REX prefix or is a 64-bit general register.
+func (e *Encoder) modeReg(name string, mode int) (register, error) {
+	r, err := e.reg(name)
+	if err != nil {
+		return r, err
+	}
+	if mode != 64 && !r.legacy() {
+		return r, fmt.Errorf("%s is not available in %d-bit mode", r.name, mode)
+	}
+	return r, nil
+}
+
+func ext(digit int) register {
+	return register{num: digit}
+}
@@ -0,0 +1,180 @@
+package x86_64
+
+import "fmt"
+
+var sseTable = []tableEntry{
+	{"movss", "xmm, xmm/m32", "F3 0F 10 /r", "SSE"},
+	{"movss", "m32, xmm", "F3 0F 11 /r", "SSE"},
+	{"movsd", "xmm, xmm/m64", "F2 0F 10 /r", "SSE2"},
+	{"movsd", "m64, xmm", "F2 0F 11 /r", "SSE2"},
+	{"movaps", "xmm, xmm/m128", "0F 28 /r", "SSE"},
+	{"movaps", "m128, xmm", "0F 29 /r", "SSE"},
+	{"movups", "xmm, xmm/m128", "0F 10 /r", "SSE"},
+	{"movups", "m128, xmm", "0F 11 /r", "SSE"},
+	{"movapd", "xmm, xmm/m128", "66 0F 28 /r", "SSE2"},
+	{"movapd", "m128, xmm", "66 0F 29 /r", "SSE2"},
+	{"movupd", "xmm, xmm/m128", "66 0F 10 /r", "SSE2"},
+	{"movupd", "m128, xmm", "66 0F 11 /r", "SSE2"},
+	{"movdqa", "xmm, xmm/m128", "66 0F 6F /r", "SSE2"},
+	{"movdqa", "m128, xmm", "66 0F 7F /r", "SSE2"},
+	{"movdqu", "xmm, xmm/m128", "F3 0F 6F /r", "SSE2"},
+	{"movdqu", "m128, xmm", "F3 0F 7F /r", "SSE2"},
+	{"movntps", "m128, xmm", "0F 2B /r", "SSE"},
+	{"movntpd", "m128, xmm", "66 0F 2B /r", "SSE2"},
+	{"movntdq", "m128, xmm", "66 0F E7 /r", "SSE2"},
+	{"movhlps", "xmm, xmm", "0F 12 /r", "SSE"},
+	{"movlhps", "xmm, xmm", "0F 16 /r", "SSE"},
+	{"movlps", "xmm, m64", "0F 12 /r", "SSE"},
+	{"movlps", "m64, xmm", "0F 13 /r", "SSE"},
+	{"movhps", "xmm, m64", "0F 16 /r", "SSE"},
+	{"movhps", "m64, xmm", "0F 17 /r", "SSE"},
+	{"movmskps", "r32, xmm", "0F 50 /r", "SSE"},
+	{"movmskpd", "r32, xmm", "66 0F 50 /r", "SSE2"},
+
+	{"movd", "xmm, r/m32", "66 0F 6E /r", "SSE2"},
+	{"movd", "r/m32, xmm", "66 0F 7E /r", "SSE2"},
+	{"movq", "xmm, xmm/m64", "F3 0F 7E /r", "SSE2"},
+	{"movq", "m64, xmm", "66 0F D6 /r", "SSE2"},
+	{"movq", "xmm, r/m64", "66 REX.W 0F 6E /r", "SSE2"},
+	{"movq", "r/m64, xmm", "66 REX.W 0F 7E /r", "SSE2"},
+
+	{"ucomiss", "xmm, xmm/m32", "0F 2E /r", "SSE"},
+	{"ucomisd", "xmm, xmm/m64", "66 0F 2E /r", "SSE2"},
+	{"comiss", "xmm, xmm/m32", "0F 2F /r", "SSE"},
+	{"comisd", "xmm, xmm/m64", "66 0F 2F /r", "SSE2"},
+	{"cmpps", "xmm, xmm/m128, imm8", "0F C2 /r ib", "SSE"},
+	{"cmppd", "xmm, xmm/m128, imm8", "66 0F C2 /r ib", "SSE2"},
+	{"cmpss", "xmm, xmm/m32, imm8", "F3 0F C2 /r ib", "SSE"},
+	{"cmpsd", "xmm, xmm/m64, imm8", "F2 0F C2 /r ib", "SSE2"},
+	{"shufps", "xmm, xmm/m128, imm8", "0F C6 /r ib", "SSE"},
+	{"shufpd", "xmm, xmm/m128, imm8", "66 0F C6 /r ib", "SSE2"},
+	{"unpcklps", "xmm, xmm/m128", "0F 14 /r", "SSE"},
+	{"unpckhps", "xmm, xmm/m128", "0F 15 /r", "SSE"},
+	{"unpcklpd", "xmm, xmm/m128", "66 0F 14 /r", "SSE2"},
+	{"unpckhpd", "xmm, xmm/m128", "66 0F 15 /r", "SSE2"},
+	{"rcpps", "xmm, xmm/m128", "0F 53 /r", "SSE"},
+	{"rcpss", "xmm, xmm/m32", "F3 0F 53 /r", "SSE"},
+	{"rsqrtps", "xmm, xmm/m128", "0F 52 /r", "SSE"},
+	{"rsqrtss", "xmm, xmm/m32", "F3 0F 52 /r", "SSE"},
+
+	{"cvtsi2ss", "xmm, r/m32", "F3 0F 2A /r", "SSE"},
+	{"cvtsi2ss", "xmm, r/m64", "F3 REX.W 0F 2A /r", "SSE"},
+	{"cvtsi2sd", "xmm, r/m32", "F2 0F 2A /r", "SSE2"},
+	{"cvtsi2sd", "xmm, r/m64", "F2 REX.W 0F 2A /r", "SSE2"},
+	{"cvtss2si", "r32, xmm/m32", "F3 0F 2D /r", "SSE"},
+	{"cvtss2si", "r64, xmm/m32", "F3 REX.W 0F 2D /r", "SSE"},
+	{"cvttss2si", "r32, xmm/m32", "F3 0F 2C /r", "SSE"},
+	{"cvttss2si", "r64, xmm/m32", "F3 REX.W 0F 2C /r", "SSE"},
+	{"cvtsd2si", "r32, xmm/m64", "F2 0F 2D /r", "SSE2"},
+	{"cvtsd2si", "r64, xmm/m64", "F2 REX.W 0F 2D /r", "SSE2"},
+	{"cvttsd2si", "r32, xmm/m64", "F2 0F 2C /r", "SSE2"},
+	{"cvttsd2si", "r64, xmm/m64", "F2 REX.W 0F 2C /r", "SSE2"},
+	{"cvtss2sd", "xmm, xmm/m32", "F3 0F 5A /r", "SSE2"},
+	{"cvtsd2ss", "xmm, xmm/m64", "F2 0F 5A /r", "SSE2"},
+	{"cvtps2pd", "xmm, xmm/m64", "0F 5A /r", "SSE2"},
+	{"cvtpd2ps", "xmm, xmm/m128", "66 0F 5A /r", "SSE2"},
+	{"cvtdq2ps", "xmm, xmm/m128", "0F 5B /r", "SSE2"},
+	{"cvtps2dq", "xmm, xmm/m128", "66 0F 5B /r", "SSE2"},
+	{"cvttps2dq", "xmm, xmm/m128", "F3 0F 5B /r", "SSE2"},
+	{"cvtdq2pd", "xmm, xmm/m64", "F3 0F E6 /r", "SSE2"},
+	{"cvtpd2dq", "xmm, xmm/m128", "F2 0F E6 /r", "SSE2"},
+	{"cvttpd2dq", "xmm, xmm/m128", "66 0F E6 /r", "SSE2"},
+
+	{"pshufd", "xmm, xmm/m128, imm8", "66 0F 70 /r ib", "SSE2"},
+	{"pshuflw", "xmm, xmm/m128, imm8", "F2 0F 70 /r ib", "SSE2"},
+	{"pshufhw", "xmm, xmm/m128, imm8", "F3 0F 70 /r ib", "SSE2"},
+	{"pmovmskb", "r32, xmm", "66 0F D7 /r", "SSE2"},
+	{"pextrw", "r32, xmm, imm8", "66 0F C5 /r ib", "SSE2"},
+	{"pinsrw", "xmm, r32, imm8", "66 0F C4 /r ib", "SSE2"},
+	{"pinsrw", "xmm, m16, imm8", "66 0F C4 /r ib", "SSE2"},
+
+	{"ldmxcsr", "m32", "0F AE /2", "SSE"},
+	{"stmxcsr", "m32", "0F AE /3", "SSE"},
+}
+
+// sseArithOps are the floating point operations that come in packed and
+// scalar, single and double precision variants. The variant is selected
+// by the mandatory prefix alone.
+var sseArithOps = []struct {
+	name   string
+	opcode byte
+	scalar bool
+}{
+	{"add", 0x58, true},
+	{"mul", 0x59, true},
+	{"sub", 0x5C, true},
+	{"min", 0x5D, true},
+	{"div", 0x5E, true},
+	{"max", 0x5F, true},
+	{"sqrt", 0x51, true},
+	{"and", 0x54, false},
+	{"andn", 0x55, false},
+	{"or", 0x56, false},
+	{"xor", 0x57, false},
+}
+
+func sseArithTable() []tableEntry {
+	var out []tableEntry
+	for _, op := range sseArithOps {
+		enc := fmt.Sprintf("0F %02X /r", op.opcode)
+		out = append(out,
+			tableEntry{op.name + "ps", "xmm, xmm/m128", enc, "SSE"},
+			tableEntry{op.name + "pd", "xmm, xmm/m128", "66 " + enc, "SSE2"},
+		)
+		if op.scalar {
+			out = append(out,
+				tableEntry{op.name + "ss", "xmm, xmm/m32", "F3 " + enc, "SSE"},
+				tableEntry{op.name + "sd", "xmm, xmm/m64", "F2 " + enc, "SSE2"},
+			)
+		}
+	}
+	return out
+}
+
+// sse2IntOps are the integer SSE2 operations, which all take
+// xmm, xmm/m128 and are encoded as 66 0F op /r.
+var sse2IntOps = []struct {
+	name   string
+	opcode byte
+}{
+	{"paddb", 0xFC}, {"paddw", 0xFD}, {"paddd", 0xFE}, {"paddq", 0xD4},
+	{"psubb", 0xF8}, {"psubw", 0xF9}, {"psubd", 0xFA}, {"psubq", 0xFB},
+	{"paddsb", 0xEC}, {"paddsw", 0xED}, {"paddusb", 0xDC}, {"paddusw", 0xDD},
+	{"psubsb", 0xE8}, {"psubsw", 0xE9}, {"psubusb", 0xD8}, {"psubusw", 0xD9},
+	{"pmullw", 0xD5}, {"pmulhw", 0xE5}, {"pmulhuw", 0xE4}, {"pmuludq", 0xF4},
+	{"pmaddwd", 0xF5}, {"psadbw", 0xF6}, {"pavgb", 0xE0}, {"pavgw", 0xE3},
+	{"pmaxsw", 0xEE}, {"pmaxub", 0xDE}, {"pminsw", 0xEA}, {"pminub", 0xDA},
+	{"pand", 0xDB}, {"pandn", 0xDF}, {"por", 0xEB}, {"pxor", 0xEF},
+	{"pcmpeqb", 0x74}, {"pcmpeqw", 0x75}, {"pcmpeqd", 0x76},
+	{"pcmpgtb", 0x64}, {"pcmpgtw", 0x65}, {"pcmpgtd", 0x66},
+	{"packsswb", 0x63}, {"packssdw", 0x6B}, {"packuswb", 0x67},
+	{"punpcklbw", 0x60}, {"punpcklwd", 0x61}, {"punpckldq", 0x62}, {"punpcklqdq", 0x6C},
+	{"punpckhbw", 0x68}, {"punpckhwd", 0x69}, {"punpckhdq", 0x6A}, {"punpckhqdq", 0x6D},
+	{"psllw", 0xF1}, {"pslld", 0xF2}, {"psllq", 0xF3},
+	{"psrlw", 0xD1}, {"psrld", 0xD2}, {"psrlq", 0xD3},
+	{"psraw", 0xE1}, {"psrad", 0xE2},
+}
+
+// sseShiftImmOps are the shifts by an immediate count, which keep the
+// operation in the ModRM reg field.
+var sseShiftImmOps = []struct {
+	name   string
+	opcode byte
+	digit  int
+}{
+	{"psllw", 0x71, 6}, {"psraw", 0x71, 4}, {"psrlw", 0x71, 2},
+	{"pslld", 0x72, 6}, {"psrad", 0x72, 4}, {"psrld", 0x72, 2},
+	{"psllq", 0x73, 6}, {"psrlq", 0x73, 2},
+	{"pslldq", 0x73, 7}, {"psrldq", 0x73, 3},
+}
+
+func sse2IntTable() []tableEntry {
+	var out []tableEntry
+	for _, op := range sse2IntOps {
+		out = append(out, tableEntry{op.name, "xmm, xmm/m128", fmt.Sprintf("66 0F %02X /r", op.opcode), "SSE2"})
+	}
+	for _, op := range sseShiftImmOps {
+		out = append(out, tableEntry{op.name, "xmm, imm8", fmt.Sprintf("66 0F %02X /%d ib", op.opcode, op.digit), "SSE2"})
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package x86_64
+
+// systemTable lists the privileged and system instructions used by kernel
+// and boot code. Control and debug register moves use general registers of
+// the mode's native width; the register in ModRM.reg is the control
+// register, so the reading forms are marked MR.
+var systemTable = []tableEntry{
+	{"mov", "r64, cr", "MR 0F 20 /r", ""},
+	{"mov", "cr, r64", "0F 22 /r", ""},
+	{"mov", "r64, dr", "MR 0F 21 /r", ""},
+	{"mov", "dr, r64", "0F 23 /r", ""},
+	{"mov", "r32, cr", "i64 MR 0F 20 /r", ""},
+	{"mov", "cr, r32", "i64 0F 22 /r", ""},
+	{"mov", "r32, dr", "i64 MR 0F 21 /r", ""},
+	{"mov", "dr, r32", "i64 0F 23 /r", ""},
+
+	{"cpuid", "", "0F A2", ""},
+	{"rdtsc", "", "0F 31", ""},
+	{"rdtscp", "", "0F 01 F9", "RDTSCP"},
+	{"rdmsr", "", "0F 32", ""},
+	{"wrmsr", "", "0F 30", ""},
+	{"rdpmc", "", "0F 33", ""},
+	{"xgetbv", "", "0F 01 D0", "XSAVE"},
+	{"xsetbv", "", "0F 01 D1", "XSAVE"},
+
+	{"hlt", "", "F4", ""},
+	{"cli", "", "FA", ""},
+	{"sti", "", "FB", ""},
+	{"clts", "", "0F 06", ""},
+	{"invd", "", "0F 08", ""},
+	{"wbinvd", "", "0F 09", ""},
+	{"invlpg", "m", "0F 01 /7", ""},
+	{"lgdt", "m", "0F 01 /2", ""},
+	{"lidt", "m", "0F 01 /3", ""},
+	{"sgdt", "m", "0F 01 /0", ""},
+	{"sidt", "m", "0F 01 /1", ""},
+	{"lldt", "r/m16", "0F 00 /2", ""},
+	{"ltr", "r/m16", "0F 00 /3", ""},
+
+	{"iret", "", "CF", ""},
+	{"iretd", "", "o32 CF", ""},
+	{"iretq", "", "REX.W CF", ""},
+	{"sysret", "", "o64 0F 07", ""},
+	{"sysretq", "", "REX.W 0F 07", ""},
+	{"swapgs", "", "o64 0F 01 F8", ""},
+	{"rdfsbase", "r32", "o64 F3 0F AE /0", "FSGSBASE"},
+	{"rdfsbase", "r64", "F3 REX.W 0F AE /0", "FSGSBASE"},
+	{"rdgsbase", "r32", "o64 F3 0F AE /1", "FSGSBASE"},
+	{"rdgsbase", "r64", "F3 REX.W 0F AE /1", "FSGSBASE"},
+	{"wrfsbase", "r32", "o64 F3 0F AE /2", "FSGSBASE"},
+	{"wrfsbase", "r64", "F3 REX.W 0F AE /2", "FSGSBASE"},
+	{"wrgsbase", "r32", "o64 F3 0F AE /3", "FSGSBASE"},
+	{"wrgsbase", "r64", "F3 REX.W 0F AE /3", "FSGSBASE"},
+
+	{"in", "al, imm8", "E4 ib", ""},
+	{"in", "ax, imm8", "o16 E5 ib", ""},
+	{"in", "eax, imm8", "E5 ib", ""},
+	{"in", "al, dx", "EC", ""},
+	{"in", "ax, dx", "o16 ED", ""},
+	{"in", "eax, dx", "ED", ""},
+	{"out", "imm8, al", "E6 ib", ""},
+	{"out", "imm8, ax", "o16 E7 ib", ""},
+	{"out", "imm8, eax", "E7 ib", ""},
+	{"out", "dx, al", "EE", ""},
+	{"out", "dx, ax", "o16 EF", ""},
+	{"out", "dx, eax", "o32 EF", ""},
+}
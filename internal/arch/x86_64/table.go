@@ -0,0 +1,415 @@
+package x86_64
+
+import "fmt"
+
+// The instruction table lists every supported encoding in the notation of
+// the Intel SDM opcode column. Forms of a mnemonic are tried in order and
+// the first whose operand pattern matches is used, so shorter encodings
+// must come before longer ones.
+//
+// Operand patterns:
+//
+//	r8..r64     general register, encoded in ModRM.reg or the opcode; a
+//	            second register operand goes in ModRM.rm
+//	r/m8..r/m64 general register or memory, encoded in ModRM.rm
+//	xmm..zmm    vector register; zmm and registers 16-31 need EVEX
+//	xmm/m8..    vector register or memory of the given size
+//	k, k/m16    opmask register, or opmask register or memory
+//	st          x87 stack register st0-st7
+//	cr, dr      control or debug register
+//	sreg        segment register es, cs, ss, ds, fs or gs
+//	m, m8..m512 memory only; m accepts any size, m80 is a tword
+//	../m32bcst  also accepts a memory element broadcast with {1toN}
+//	{k}{z}      accepts a {k1}..{k7} write mask and {z} zeroing
+//	{er}, {sae} the form takes a trailing {rn-sae}.. or {sae} operand
+//	imm8..imm64 immediate of the given width
+//	simm8       imm8 sign-extended to the operand size
+//	simm32      imm32 sign-extended to 64 bits
+//	uimm32      imm32 zero-extended to 64 bits
+//	            simm and uimm forms take constants only and are skipped
+//	            when immediate size optimization is off
+//	rel8, rel32 branch target relative to the next instruction; rel32
+//	            is a rel16 in 16-bit mode
+//	ptr16:16..  far pointer given as selector:offset
+//	al, cl, ... that exact register, not encoded
+//
+// Encoding tokens:
+//
+//	o16     16-bit operand size: prefix 66 outside 16-bit mode
+//	o32     32-bit operand size: prefix 66 in 16-bit mode; implied for
+//	        legacy forms sized by a 32-bit general register
+//	a16..a64 address size; prefix 67 when it differs from the mode's
+//	REX.W   64-bit operand size
+//	d64     defaults to 64-bit operand size, so unsized memory is accepted
+//	i64     invalid in 64-bit mode
+//	o64     valid only in 64-bit mode, as are REX.W and r64 forms
+//	wait    an FWAIT (9B) emitted ahead of all prefixes
+//	lock    accepts a lock prefix when the r/m operand is memory
+//	rep     accepts a rep prefix
+//	repe    accepts rep, repe and repne prefixes
+//	XX      a prefix (66, F2, F3 before the opcode) or opcode byte
+//	XX+r    opcode byte with the register number added
+//	/r, /0-7 ModRM byte, with a register or an opcode extension in reg
+//	MR      the first register operand goes in ModRM.rm, the second in reg
+//	RMV     the third operand goes in VEX.vvvv
+//	ib..io  immediate bytes; cb, cd relative branch offset
+//	VEX.NDS.256.66.0F38.W0, EVEX.NDS.512.66.0F.W0
+//	        VEX or EVEX prefix in SDM notation, replacing REX and the
+//	        mandatory prefix; see parseVex
+//
+// The feature column names the CPU features a form needs, separated by
+// commas when there are several, as in "AES,AVX".
+type tableEntry struct {
+	mnemonic string
+	operands string
+	encoding string
+	feature  string
+}
+
+var baseTable = []tableEntry{
+	{"mov", "r/m8, r8", "88 /r", ""},
+	{"mov", "r/m16, r16", "o16 89 /r", ""},
+	{"mov", "r/m32, r32", "89 /r", ""},
+	{"mov", "r/m64, r64", "REX.W 89 /r", ""},
+	{"mov", "r8, r/m8", "8A /r", ""},
+	{"mov", "r16, r/m16", "o16 8B /r", ""},
+	{"mov", "r32, r/m32", "8B /r", ""},
+	{"mov", "r64, r/m64", "REX.W 8B /r", ""},
+	{"mov", "r8, imm8", "B0+r ib", ""},
+	{"mov", "r16, imm16", "o16 B8+r iw", ""},
+	{"mov", "r32, imm32", "B8+r id", ""},
+	{"mov", "r64, uimm32", "B8+r id", ""},
+	{"mov", "r64, simm32", "REX.W C7 /0 id", ""},
+	{"mov", "r64, imm64", "REX.W B8+r io", ""},
+	{"mov", "r/m8, imm8", "C6 /0 ib", ""},
+	{"mov", "r/m16, imm16", "o16 C7 /0 iw", ""},
+	{"mov", "r/m32, imm32", "C7 /0 id", ""},
+	{"mov", "r/m64, imm32", "REX.W C7 /0 id", ""},
+	{"mov", "m16, sreg", "8C /r", ""},
+	{"mov", "r16, sreg", "MR o16 8C /r", ""},
+	{"mov", "r32, sreg", "MR 8C /r", ""},
+	{"mov", "r64, sreg", "MR REX.W 8C /r", ""},
+	{"mov", "sreg, m16", "8E /r", ""},
+	{"mov", "sreg, r16", "o16 8E /r", ""},
+	{"mov", "sreg, r32", "8E /r", ""},
+	{"mov", "sreg, r64", "REX.W 8E /r", ""},
+
+	{"test", "al, imm8", "A8 ib", ""},
+	{"test", "ax, imm16", "o16 A9 iw", ""},
+	{"test", "eax, imm32", "A9 id", ""},
+	{"test", "rax, imm32", "REX.W A9 id", ""},
+	{"test", "r/m8, imm8", "F6 /0 ib", ""},
+	{"test", "r/m16, imm16", "o16 F7 /0 iw", ""},
+	{"test", "r/m32, imm32", "F7 /0 id", ""},
+	{"test", "r/m64, imm32", "REX.W F7 /0 id", ""},
+	{"test", "r/m8, r8", "84 /r", ""},
+	{"test", "r/m16, r16", "o16 85 /r", ""},
+	{"test", "r/m32, r32", "85 /r", ""},
+	{"test", "r/m64, r64", "REX.W 85 /r", ""},
+	{"test", "r8, m8", "84 /r", ""},
+	{"test", "r16, m16", "o16 85 /r", ""},
+	{"test", "r32, m32", "85 /r", ""},
+	{"test", "r64, m64", "REX.W 85 /r", ""},
+
+	{"inc", "r16", "i64 o16 40+r", ""},
+	{"inc", "r32", "i64 40+r", ""},
+	{"inc", "r/m8", "lock FE /0", ""},
+	{"inc", "r/m16", "lock o16 FF /0", ""},
+	{"inc", "r/m32", "lock FF /0", ""},
+	{"inc", "r/m64", "lock REX.W FF /0", ""},
+	{"dec", "r16", "i64 o16 48+r", ""},
+	{"dec", "r32", "i64 48+r", ""},
+	{"dec", "r/m8", "lock FE /1", ""},
+	{"dec", "r/m16", "lock o16 FF /1", ""},
+	{"dec", "r/m32", "lock FF /1", ""},
+	{"dec", "r/m64", "lock REX.W FF /1", ""},
+
+	{"lea", "r16, m", "o16 8D /r", ""},
+	{"lea", "r32, m", "8D /r", ""},
+	{"lea", "r64, m", "REX.W 8D /r", ""},
+
+	{"push", "r64", "d64 50+r", ""},
+	{"push", "r16", "o16 50+r", ""},
+	{"push", "r32", "i64 50+r", ""},
+	{"push", "r/m64", "d64 FF /6", ""},
+	{"push", "r/m16", "o16 FF /6", ""},
+	{"push", "r/m32", "i64 FF /6", ""},
+	{"push", "es", "i64 06", ""},
+	{"push", "cs", "i64 0E", ""},
+	{"push", "ss", "i64 16", ""},
+	{"push", "ds", "i64 1E", ""},
+	{"push", "fs", "0F A0", ""},
+	{"push", "gs", "0F A8", ""},
+	{"pop", "r64", "d64 58+r", ""},
+	{"pop", "r16", "o16 58+r", ""},
+	{"pop", "r32", "i64 58+r", ""},
+	{"pop", "r/m64", "d64 8F /0", ""},
+	{"pop", "r/m16", "o16 8F /0", ""},
+	{"pop", "r/m32", "i64 8F /0", ""},
+	{"pop", "es", "i64 07", ""},
+	{"pop", "ss", "i64 17", ""},
+	{"pop", "ds", "i64 1F", ""},
+	{"pop", "fs", "0F A1", ""},
+	{"pop", "gs", "0F A9", ""},
+	{"pusha", "", "i64 60", ""},
+	{"pushaw", "", "i64 o16 60", ""},
+	{"pushad", "", "i64 o32 60", ""},
+	{"popa", "", "i64 61", ""},
+	{"popaw", "", "i64 o16 61", ""},
+	{"popad", "", "i64 o32 61", ""},
+	{"pushf", "", "9C", ""},
+	{"pushfw", "", "o16 9C", ""},
+	{"pushfd", "", "i64 o32 9C", ""},
+	{"pushfq", "", "o64 9C", ""},
+	{"popf", "", "9D", ""},
+	{"popfw", "", "o16 9D", ""},
+	{"popfd", "", "i64 o32 9D", ""},
+	{"popfq", "", "o64 9D", ""},
+
+	{"jmp", "rel8", "EB cb", ""},
+	{"jmp", "rel32", "E9 cd", ""},
+	{"jmp", "r/m64", "d64 FF /4", ""},
+	{"jmp", "r/m16", "i64 o16 FF /4", ""},
+	{"jmp", "r/m32", "i64 FF /4", ""},
+	{"jmp", "ptr16:16", "i64 o16 EA", ""},
+	{"jmp", "ptr16:32", "i64 o32 EA", ""},
+	{"call", "rel32", "E8 cd", ""},
+	{"call", "r/m64", "d64 FF /2", ""},
+	{"call", "r/m16", "i64 o16 FF /2", ""},
+	{"call", "r/m32", "i64 FF /2", ""},
+	{"call", "ptr16:16", "i64 o16 9A", ""},
+	{"call", "ptr16:32", "i64 o32 9A", ""},
+	{"ret", "", "C3", ""},
+	{"retf", "", "CB", ""},
+
+	{"jrcxz", "rel8", "a64 E3 cb", ""},
+	{"jecxz", "rel8", "a32 E3 cb", ""},
+	{"jcxz", "rel8", "i64 a16 E3 cb", ""},
+	{"loop", "rel8", "E2 cb", ""},
+	{"loope", "rel8", "E1 cb", ""},
+	{"loopz", "rel8", "E1 cb", ""},
+	{"loopne", "rel8", "E0 cb", ""},
+	{"loopnz", "rel8", "E0 cb", ""},
+
+	// xchg eax, eax cannot use 90, which is nop and leaves the upper
+	// half of rax alone.
+	{"xchg", "eax, eax", "87 C0", ""},
+	{"xchg", "ax, r16", "o16 90+r", ""},
+	{"xchg", "r16, ax", "o16 90+r", ""},
+	{"xchg", "eax, r32", "90+r", ""},
+	{"xchg", "r32, eax", "90+r", ""},
+	{"xchg", "rax, r64", "REX.W 90+r", ""},
+	{"xchg", "r64, rax", "REX.W 90+r", ""},
+	{"xchg", "r/m8, r8", "lock 86 /r", ""},
+	{"xchg", "r/m16, r16", "lock o16 87 /r", ""},
+	{"xchg", "r/m32, r32", "lock 87 /r", ""},
+	{"xchg", "r/m64, r64", "lock REX.W 87 /r", ""},
+	{"xchg", "r8, m8", "lock 86 /r", ""},
+	{"xchg", "r16, m16", "lock o16 87 /r", ""},
+	{"xchg", "r32, m32", "lock 87 /r", ""},
+	{"xchg", "r64, m64", "lock REX.W 87 /r", ""},
+	{"cmpxchg", "r/m8, r8", "lock 0F B0 /r", ""},
+	{"cmpxchg", "r/m16, r16", "lock o16 0F B1 /r", ""},
+	{"cmpxchg", "r/m32, r32", "lock 0F B1 /r", ""},
+	{"cmpxchg", "r/m64, r64", "lock REX.W 0F B1 /r", ""},
+	{"cmpxchg8b", "m64", "lock 0F C7 /1", ""},
+	{"cmpxchg16b", "m128", "lock REX.W 0F C7 /1", "CX16"},
+	{"xadd", "r/m8, r8", "lock 0F C0 /r", ""},
+	{"xadd", "r/m16, r16", "lock o16 0F C1 /r", ""},
+	{"xadd", "r/m32, r32", "lock 0F C1 /r", ""},
+	{"xadd", "r/m64, r64", "lock REX.W 0F C1 /r", ""},
+	{"mfence", "", "0F AE F0", "SSE2"},
+	{"lfence", "", "0F AE E8", "SSE2"},
+	{"sfence", "", "0F AE F8", "SSE"},
+	{"pause", "", "F3 90", ""},
+
+	{"movsb", "", "rep A4", ""},
+	{"movsw", "", "rep o16 A5", ""},
+	{"movsd", "", "rep o32 A5", ""},
+	{"movsq", "", "rep REX.W A5", ""},
+	{"stosb", "", "rep AA", ""},
+	{"stosw", "", "rep o16 AB", ""},
+	{"stosd", "", "rep o32 AB", ""},
+	{"stosq", "", "rep REX.W AB", ""},
+	{"lodsb", "", "rep AC", ""},
+	{"lodsw", "", "rep o16 AD", ""},
+	{"lodsd", "", "rep o32 AD", ""},
+	{"lodsq", "", "rep REX.W AD", ""},
+	{"scasb", "", "repe AE", ""},
+	{"scasw", "", "repe o16 AF", ""},
+	{"scasd", "", "repe o32 AF", ""},
+	{"scasq", "", "repe REX.W AF", ""},
+	{"cmpsb", "", "repe A6", ""},
+	{"cmpsw", "", "repe o16 A7", ""},
+	{"cmpsd", "", "repe o32 A7", ""},
+	{"cmpsq", "", "repe REX.W A7", ""},
+
+	{"syscall", "", "0F 05", ""},
+	{"nop", "", "90", ""},
+	{"int", "imm8", "CD ib", ""},
+}
+
+// aluTable generates the eight classic ALU instructions, which share one
+// layout: the operation number selects the opcode row and the /digit.
+func aluTable() []tableEntry {
+	var out []tableEntry
+	for i, mn := range []string{"add", "or", "adc", "sbb", "and", "sub", "xor", "cmp"} {
+		op := func(n int) string { return fmt.Sprintf("%02X", i<<3+n) }
+		d := fmt.Sprintf("/%d", i)
+		lock := "lock "
+		if mn == "cmp" {
+			lock = ""
+		}
+		out = append(out,
+			tableEntry{mn, "al, imm8", op(4) + " ib", ""},
+			tableEntry{mn, "r/m8, imm8", lock + "80 " + d + " ib", ""},
+			tableEntry{mn, "r/m16, simm8", lock + "o16 83 " + d + " ib", ""},
+			tableEntry{mn, "r/m32, simm8", lock + "83 " + d + " ib", ""},
+			tableEntry{mn, "r/m64, simm8", lock + "REX.W 83 " + d + " ib", ""},
+			tableEntry{mn, "ax, imm16", "o16 " + op(5) + " iw", ""},
+			tableEntry{mn, "eax, imm32", op(5) + " id", ""},
+			tableEntry{mn, "rax, imm32", "REX.W " + op(5) + " id", ""},
+			tableEntry{mn, "r/m16, imm16", lock + "o16 81 " + d + " iw", ""},
+			tableEntry{mn, "r/m32, imm32", lock + "81 " + d + " id", ""},
+			tableEntry{mn, "r/m64, imm32", lock + "REX.W 81 " + d + " id", ""},
+			tableEntry{mn, "r/m8, r8", lock + op(0) + " /r", ""},
+			tableEntry{mn, "r/m16, r16", lock + "o16 " + op(1) + " /r", ""},
+			tableEntry{mn, "r/m32, r32", lock + op(1) + " /r", ""},
+			tableEntry{mn, "r/m64, r64", lock + "REX.W " + op(1) + " /r", ""},
+			tableEntry{mn, "r8, r/m8", op(2) + " /r", ""},
+			tableEntry{mn, "r16, r/m16", "o16 " + op(3) + " /r", ""},
+			tableEntry{mn, "r32, r/m32", op(3) + " /r", ""},
+			tableEntry{mn, "r64, r/m64", "REX.W " + op(3) + " /r", ""},
+		)
+	}
+	return out
+}
+
+// shiftTable generates the rotate and shift group, each with a count of
+// one, cl or an immediate. A missing count means one.
+func shiftTable() []tableEntry {
+	var out []tableEntry
+	for _, sh := range []struct {
+		mnemonic string
+		digit    int
+	}{
+		{"rol", 0}, {"ror", 1}, {"rcl", 2}, {"rcr", 3},
+		{"shl", 4}, {"sal", 4}, {"shr", 5}, {"sar", 7},
+	} {
+		d := fmt.Sprintf(" /%d", sh.digit)
+		for _, w := range []struct {
+			size, prefix string
+			wide         byte
+		}{
+			{"8", "", 0}, {"16", "o16 ", 1}, {"32", "", 1}, {"64", "REX.W ", 1},
+		} {
+			op := func(b byte) string { return fmt.Sprintf("%s%02X", w.prefix, b+w.wide) }
+			rm := "r/m" + w.size
+			out = append(out,
+				tableEntry{sh.mnemonic, rm, op(0xD0) + d, ""},
+				tableEntry{sh.mnemonic, rm + ", 1", op(0xD0) + d, ""},
+				tableEntry{sh.mnemonic, rm + ", cl", op(0xD2) + d, ""},
+				tableEntry{sh.mnemonic, rm + ", imm8", op(0xC0) + d + " ib", ""},
+			)
+		}
+	}
+	return out
+}
+
+// unaryTable generates the F6/F7 group: not, neg and the one-operand
+// multiply and divide forms that work on rdx:rax.
+func unaryTable() []tableEntry {
+	var out []tableEntry
+	for _, u := range []struct {
+		mnemonic string
+		digit    int
+	}{
+		{"not", 2}, {"neg", 3}, {"mul", 4}, {"imul", 5}, {"div", 6}, {"idiv", 7},
+	} {
+		d := fmt.Sprintf(" /%d", u.digit)
+		lock := ""
+		if u.digit < 4 {
+			lock = "lock "
+		}
+		out = append(out,
+			tableEntry{u.mnemonic, "r/m8", lock + "F6" + d, ""},
+			tableEntry{u.mnemonic, "r/m16", lock + "o16 F7" + d, ""},
+			tableEntry{u.mnemonic, "r/m32", lock + "F7" + d, ""},
+			tableEntry{u.mnemonic, "r/m64", lock + "REX.W F7" + d, ""},
+		)
+	}
+	return out
+}
+
+// conditions lists the condition-code suffixes by their encoding, with
+// every alias NASM accepts.
+var conditions = [16][]string{
+	{"o"},
+	{"no"},
+	{"b", "c", "nae"},
+	{"ae", "nb", "nc"},
+	{"e", "z"},
+	{"ne", "nz"},
+	{"be", "na"},
+	{"a", "nbe"},
+	{"s"},
+	{"ns"},
+	{"p", "pe"},
+	{"np", "po"},
+	{"l", "nge"},
+	{"ge", "nl"},
+	{"le", "ng"},
+	{"g", "nle"},
+}
+
+// ccTable generates jcc, cmovcc and setcc for every condition code.
+func ccTable() []tableEntry {
+	var out []tableEntry
+	for cc, names := range conditions {
+		op := func(b int) string { return fmt.Sprintf("%02X", b+cc) }
+		for _, n := range names {
+			out = append(out,
+				tableEntry{"j" + n, "rel8", op(0x70) + " cb", ""},
+				tableEntry{"j" + n, "rel32", "0F " + op(0x80) + " cd", ""},
+				tableEntry{"cmov" + n, "r16, r/m16", "o16 0F " + op(0x40) + " /r", ""},
+				tableEntry{"cmov" + n, "r32, r/m32", "0F " + op(0x40) + " /r", ""},
+				tableEntry{"cmov" + n, "r64, r/m64", "REX.W 0F " + op(0x40) + " /r", ""},
+				tableEntry{"set" + n, "r/m8", "0F " + op(0x90) + " /0", ""},
+			)
+		}
+	}
+	return out
+}
+
+var imulTable = []tableEntry{
+	{"imul", "r16, r/m16", "o16 0F AF /r", ""},
+	{"imul", "r32, r/m32", "0F AF /r", ""},
+	{"imul", "r64, r/m64", "REX.W 0F AF /r", ""},
+	{"imul", "r16, r/m16, simm8", "o16 6B /r ib", ""},
+	{"imul", "r32, r/m32, simm8", "6B /r ib", ""},
+	{"imul", "r64, r/m64, simm8", "REX.W 6B /r ib", ""},
+	{"imul", "r16, r/m16, imm16", "o16 69 /r iw", ""},
+	{"imul", "r32, r/m32, imm32", "69 /r id", ""},
+	{"imul", "r64, r/m64, imm32", "REX.W 69 /r id", ""},
+}
+
+var forms = buildForms(baseTable, aluTable(), ccTable(), shiftTable(), unaryTable(), imulTable,
+	sseTable, sseArithTable(), sse2IntTable(),
+	vexTable, vexMoveTable(), vexArithTable(), vexIntTable(), fmaTable(),
+	evexTable, evexMoveTable(), evexArithTable(), evexIntTable(), evexFmaTable(),
+	x87Table, x87ArithTable(), systemTable, bitTable(), bmiTable(),
+	cryptoTable, aesTable())
+
+func buildForms(tables ...[]tableEntry) map[string][]*form {
+	out := make(map[string][]*form)
+	for _, table := range tables {
+		for _, t := range table {
+			f, err := parseForm(t.mnemonic, t.operands, t.encoding, t.feature)
+			if err != nil {
+				panic(fmt.Sprintf("x86_64: %s %s: %v", t.mnemonic, t.operands, err))
+			}
+			out[t.mnemonic] = append(out[t.mnemonic], f)
+		}
+	}
+	return out
+}
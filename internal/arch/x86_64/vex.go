@@ -0,0 +1,226 @@
+package x86_64
+
+import (
+	"fmt"
+	"strings"
+)
+
+var vexTable = []tableEntry{
+	{"vmovss", "xmm, m32", "VEX.LIG.F3.0F.WIG 10 /r", "AVX"},
+	{"vmovss", "m32, xmm", "VEX.LIG.F3.0F.WIG 11 /r", "AVX"},
+	{"vmovss", "xmm, xmm, xmm", "VEX.NDS.LIG.F3.0F.WIG 10 /r", "AVX"},
+	{"vmovsd", "xmm, m64", "VEX.LIG.F2.0F.WIG 10 /r", "AVX"},
+	{"vmovsd", "m64, xmm", "VEX.LIG.F2.0F.WIG 11 /r", "AVX"},
+	{"vmovsd", "xmm, xmm, xmm", "VEX.NDS.LIG.F2.0F.WIG 10 /r", "AVX"},
+	{"vmovd", "xmm, r/m32", "VEX.128.66.0F.W0 6E /r", "AVX"},
+	{"vmovd", "r/m32, xmm", "VEX.128.66.0F.W0 7E /r", "AVX"},
+	{"vmovq", "xmm, xmm/m64", "VEX.128.F3.0F.WIG 7E /r", "AVX"},
+	{"vmovq", "m64, xmm", "VEX.128.66.0F.WIG D6 /r", "AVX"},
+	{"vmovq", "xmm, r/m64", "VEX.128.66.0F.W1 6E /r", "AVX"},
+	{"vmovq", "r/m64, xmm", "VEX.128.66.0F.W1 7E /r", "AVX"},
+
+	{"vbroadcastss", "xmm, m32", "VEX.128.66.0F38.W0 18 /r", "AVX"},
+	{"vbroadcastss", "ymm, m32", "VEX.256.66.0F38.W0 18 /r", "AVX"},
+	{"vbroadcastss", "xmm, xmm", "VEX.128.66.0F38.W0 18 /r", "AVX2"},
+	{"vbroadcastss", "ymm, xmm", "VEX.256.66.0F38.W0 18 /r", "AVX2"},
+	{"vbroadcastsd", "ymm, m64", "VEX.256.66.0F38.W0 19 /r", "AVX"},
+	{"vbroadcastsd", "ymm, xmm", "VEX.256.66.0F38.W0 19 /r", "AVX2"},
+	{"vbroadcastf128", "ymm, m128", "VEX.256.66.0F38.W0 1A /r", "AVX"},
+	{"vbroadcasti128", "ymm, m128", "VEX.256.66.0F38.W0 5A /r", "AVX2"},
+	{"vpbroadcastb", "xmm, xmm/m8", "VEX.128.66.0F38.W0 78 /r", "AVX2"},
+	{"vpbroadcastb", "ymm, xmm/m8", "VEX.256.66.0F38.W0 78 /r", "AVX2"},
+	{"vpbroadcastw", "xmm, xmm/m16", "VEX.128.66.0F38.W0 79 /r", "AVX2"},
+	{"vpbroadcastw", "ymm, xmm/m16", "VEX.256.66.0F38.W0 79 /r", "AVX2"},
+	{"vpbroadcastd", "xmm, xmm/m32", "VEX.128.66.0F38.W0 58 /r", "AVX2"},
+	{"vpbroadcastd", "ymm, xmm/m32", "VEX.256.66.0F38.W0 58 /r", "AVX2"},
+	{"vpbroadcastq", "xmm, xmm/m64", "VEX.128.66.0F38.W0 59 /r", "AVX2"},
+	{"vpbroadcastq", "ymm, xmm/m64", "VEX.256.66.0F38.W0 59 /r", "AVX2"},
+
+	{"vpermq", "ymm, ymm/m256, imm8", "VEX.256.66.0F3A.W1 00 /r ib", "AVX2"},
+	{"vpermpd", "ymm, ymm/m256, imm8", "VEX.256.66.0F3A.W1 01 /r ib", "AVX2"},
+	{"vpermd", "ymm, ymm, ymm/m256", "VEX.NDS.256.66.0F38.W0 36 /r", "AVX2"},
+	{"vpermps", "ymm, ymm, ymm/m256", "VEX.NDS.256.66.0F38.W0 16 /r", "AVX2"},
+	{"vperm2f128", "ymm, ymm, ymm/m256, imm8", "VEX.NDS.256.66.0F3A.W0 06 /r ib", "AVX"},
+	{"vperm2i128", "ymm, ymm, ymm/m256, imm8", "VEX.NDS.256.66.0F3A.W0 46 /r ib", "AVX2"},
+	{"vinsertf128", "ymm, ymm, xmm/m128, imm8", "VEX.NDS.256.66.0F3A.W0 18 /r ib", "AVX"},
+	{"vinserti128", "ymm, ymm, xmm/m128, imm8", "VEX.NDS.256.66.0F3A.W0 38 /r ib", "AVX2"},
+	{"vextractf128", "xmm/m128, ymm, imm8", "VEX.256.66.0F3A.W0 19 /r ib", "AVX"},
+	{"vextracti128", "xmm/m128, ymm, imm8", "VEX.256.66.0F3A.W0 39 /r ib", "AVX2"},
+
+	{"vpshufd", "xmm, xmm/m128, imm8", "VEX.128.66.0F.WIG 70 /r ib", "AVX"},
+	{"vpshufd", "ymm, ymm/m256, imm8", "VEX.256.66.0F.WIG 70 /r ib", "AVX2"},
+	{"vpshufb", "xmm, xmm, xmm/m128", "VEX.NDS.128.66.0F38.WIG 00 /r", "AVX"},
+	{"vpshufb", "ymm, ymm, ymm/m256", "VEX.NDS.256.66.0F38.WIG 00 /r", "AVX2"},
+	{"vpmovmskb", "r32, xmm", "VEX.128.66.0F.WIG D7 /r", "AVX"},
+	{"vpmovmskb", "r32, ymm", "VEX.256.66.0F.WIG D7 /r", "AVX2"},
+	{"vptest", "xmm, xmm/m128", "VEX.128.66.0F38.WIG 17 /r", "AVX"},
+	{"vptest", "ymm, ymm/m256", "VEX.256.66.0F38.WIG 17 /r", "AVX"},
+	{"vshufps", "xmm, xmm, xmm/m128, imm8", "VEX.NDS.128.0F.WIG C6 /r ib", "AVX"},
+	{"vshufps", "ymm, ymm, ymm/m256, imm8", "VEX.NDS.256.0F.WIG C6 /r ib", "AVX"},
+	{"vshufpd", "xmm, xmm, xmm/m128, imm8", "VEX.NDS.128.66.0F.WIG C6 /r ib", "AVX"},
+	{"vshufpd", "ymm, ymm, ymm/m256, imm8", "VEX.NDS.256.66.0F.WIG C6 /r ib", "AVX"},
+
+	{"vucomiss", "xmm, xmm/m32", "VEX.LIG.0F.WIG 2E /r", "AVX"},
+	{"vucomisd", "xmm, xmm/m64", "VEX.LIG.66.0F.WIG 2E /r", "AVX"},
+	{"vcomiss", "xmm, xmm/m32", "VEX.LIG.0F.WIG 2F /r", "AVX"},
+	{"vcomisd", "xmm, xmm/m64", "VEX.LIG.66.0F.WIG 2F /r", "AVX"},
+	{"vcvtsi2ss", "xmm, xmm, r/m32", "VEX.NDS.LIG.F3.0F.W0 2A /r", "AVX"},
+	{"vcvtsi2ss", "xmm, xmm, r/m64", "VEX.NDS.LIG.F3.0F.W1 2A /r", "AVX"},
+	{"vcvtsi2sd", "xmm, xmm, r/m32", "VEX.NDS.LIG.F2.0F.W0 2A /r", "AVX"},
+	{"vcvtsi2sd", "xmm, xmm, r/m64", "VEX.NDS.LIG.F2.0F.W1 2A /r", "AVX"},
+	{"vcvttss2si", "r32, xmm/m32", "VEX.LIG.F3.0F.W0 2C /r", "AVX"},
+	{"vcvttss2si", "r64, xmm/m32", "VEX.LIG.F3.0F.W1 2C /r", "AVX"},
+	{"vcvttsd2si", "r32, xmm/m64", "VEX.LIG.F2.0F.W0 2C /r", "AVX"},
+	{"vcvttsd2si", "r64, xmm/m64", "VEX.LIG.F2.0F.W1 2C /r", "AVX"},
+	{"vcvtss2sd", "xmm, xmm, xmm/m32", "VEX.NDS.LIG.F3.0F.WIG 5A /r", "AVX"},
+	{"vcvtsd2ss", "xmm, xmm, xmm/m64", "VEX.NDS.LIG.F2.0F.WIG 5A /r", "AVX"},
+	{"vcvtdq2ps", "xmm, xmm/m128", "VEX.128.0F.WIG 5B /r", "AVX"},
+	{"vcvtdq2ps", "ymm, ymm/m256", "VEX.256.0F.WIG 5B /r", "AVX"},
+	{"vcvtps2dq", "xmm, xmm/m128", "VEX.128.66.0F.WIG 5B /r", "AVX"},
+	{"vcvtps2dq", "ymm, ymm/m256", "VEX.256.66.0F.WIG 5B /r", "AVX"},
+	{"vcvttps2dq", "xmm, xmm/m128", "VEX.128.F3.0F.WIG 5B /r", "AVX"},
+	{"vcvttps2dq", "ymm, ymm/m256", "VEX.256.F3.0F.WIG 5B /r", "AVX"},
+
+	{"vzeroupper", "", "VEX.128.0F.WIG 77", "AVX"},
+	{"vzeroall", "", "VEX.256.0F.WIG 77", "AVX"},
+}
+
+// vexMoveTable generates the full-width moves, each with a load form and
+// a store form at both vector lengths.
+func vexMoveTable() []tableEntry {
+	var out []tableEntry
+	for _, mv := range []struct {
+		name        string
+		pp          string
+		load, store byte
+	}{
+		{"vmovaps", "", 0x28, 0x29},
+		{"vmovups", "", 0x10, 0x11},
+		{"vmovapd", "66.", 0x28, 0x29},
+		{"vmovupd", "66.", 0x10, 0x11},
+		{"vmovdqa", "66.", 0x6F, 0x7F},
+		{"vmovdqu", "F3.", 0x6F, 0x7F},
+	} {
+		for _, l := range []string{"128", "256"} {
+			x, rm, m := vexRegs(l)
+			vex := "VEX." + l + "." + mv.pp + "0F.WIG"
+			out = append(out,
+				tableEntry{mv.name, x + ", " + rm, fmt.Sprintf("%s %02X /r", vex, mv.load), "AVX"},
+				tableEntry{mv.name, m + ", " + x, fmt.Sprintf("%s %02X /r", vex, mv.store), "AVX"},
+			)
+		}
+	}
+	return out
+}
+
+// vexArithTable generates the three-operand forms of the SSE floating
+// point operations. Packed forms exist at both vector lengths; scalar
+// forms ignore VEX.L.
+func vexArithTable() []tableEntry {
+	var out []tableEntry
+	for _, op := range sseArithOps {
+		ops := func(x, rm string) string {
+			if op.name == "sqrt" {
+				return x + ", " + rm
+			}
+			return x + ", " + x + ", " + rm
+		}
+		nds := "NDS."
+		if op.name == "sqrt" {
+			nds = ""
+		}
+		for _, l := range []string{"128", "256"} {
+			x, rm, _ := vexRegs(l)
+			out = append(out,
+				tableEntry{"v" + op.name + "ps", ops(x, rm), fmt.Sprintf("VEX.%s%s.0F.WIG %02X /r", nds, l, op.opcode), "AVX"},
+				tableEntry{"v" + op.name + "pd", ops(x, rm), fmt.Sprintf("VEX.%s%s.66.0F.WIG %02X /r", nds, l, op.opcode), "AVX"},
+			)
+		}
+		if op.scalar {
+			out = append(out,
+				tableEntry{"v" + op.name + "ss", "xmm, xmm, xmm/m32", fmt.Sprintf("VEX.NDS.LIG.F3.0F.WIG %02X /r", op.opcode), "AVX"},
+				tableEntry{"v" + op.name + "sd", "xmm, xmm, xmm/m64", fmt.Sprintf("VEX.NDS.LIG.F2.0F.WIG %02X /r", op.opcode), "AVX"},
+			)
+		}
+	}
+	return out
+}
+
+// vexIntTable generates the VEX forms of the SSE2 integer operations. The
+// 256-bit forms need AVX2, and shifts by a register count take the count
+// from an xmm register at either length.
+func vexIntTable() []tableEntry {
+	var out []tableEntry
+	for _, op := range sse2IntOps {
+		shift := strings.HasPrefix(op.name, "psll") || strings.HasPrefix(op.name, "psr")
+		for _, l := range []string{"128", "256"} {
+			x, rm, _ := vexRegs(l)
+			if shift {
+				rm = "xmm/m128"
+			}
+			feature := "AVX"
+			if l == "256" {
+				feature = "AVX2"
+			}
+			out = append(out, tableEntry{"v" + op.name, x + ", " + x + ", " + rm, fmt.Sprintf("VEX.NDS.%s.66.0F.WIG %02X /r", l, op.opcode), feature})
+		}
+	}
+	for _, op := range sseShiftImmOps {
+		out = append(out,
+			tableEntry{"v" + op.name, "xmm, xmm, imm8", fmt.Sprintf("VEX.NDD.128.66.0F.WIG %02X /%d ib", op.opcode, op.digit), "AVX"},
+			tableEntry{"v" + op.name, "ymm, ymm, imm8", fmt.Sprintf("VEX.NDD.256.66.0F.WIG %02X /%d ib", op.opcode, op.digit), "AVX2"},
+		)
+	}
+	return out
+}
+
+// fmaTable generates the fused multiply-add family. The 132, 213 and 231
+// suffixes name the operand order and select the opcode row; W picks
+// double precision and the low opcode bit picks the scalar form.
+func fmaTable() []tableEntry {
+	var out []tableEntry
+	for _, op := range []struct {
+		name   string
+		offset byte
+		scalar bool
+	}{
+		{"fmaddsub", 0x6, false},
+		{"fmsubadd", 0x7, false},
+		{"fmadd", 0x8, true},
+		{"fmsub", 0xA, true},
+		{"fnmadd", 0xC, true},
+		{"fnmsub", 0xE, true},
+	} {
+		for _, order := range []struct {
+			suffix string
+			row    byte
+		}{{"132", 0x90}, {"213", 0xA0}, {"231", 0xB0}} {
+			opcode := order.row + op.offset
+			name := "v" + op.name + order.suffix
+			for _, l := range []string{"128", "256"} {
+				x, rm, _ := vexRegs(l)
+				ops := x + ", " + x + ", " + rm
+				out = append(out,
+					tableEntry{name + "ps", ops, fmt.Sprintf("VEX.DDS.%s.66.0F38.W0 %02X /r", l, opcode), "FMA"},
+					tableEntry{name + "pd", ops, fmt.Sprintf("VEX.DDS.%s.66.0F38.W1 %02X /r", l, opcode), "FMA"},
+				)
+			}
+			if op.scalar {
+				out = append(out,
+					tableEntry{name + "ss", "xmm, xmm, xmm/m32", fmt.Sprintf("VEX.DDS.LIG.66.0F38.W0 %02X /r", opcode+1), "FMA"},
+					tableEntry{name + "sd", "xmm, xmm, xmm/m64", fmt.Sprintf("VEX.DDS.LIG.66.0F38.W1 %02X /r", opcode+1), "FMA"},
+				)
+			}
+		}
+	}
+	return out
+}
+
+// vexRegs returns the register, register-or-memory and memory operand
+// patterns for a vector length.
+func vexRegs(l string) (reg, rm, mem string) {
+	if l == "256" {
+		return "ymm", "ymm/m256", "m256"
+	}
+	return "xmm", "xmm/m128", "m128"
+}
@@ -0,0 +1,95 @@
+package x86_64
+
+import "fmt"
+
+// x87Table lists the floating point stack instructions. Register operands
+// are encoded in the low three bits of the second opcode byte; st is any
+// of st0-st7.
+var x87Table = []tableEntry{
+	{"fld", "m32", "D9 /0", "X87"},
+	{"fld", "m64", "DD /0", "X87"},
+	{"fld", "m80", "DB /5", "X87"},
+	{"fld", "st", "D9 C0+r", "X87"},
+	{"fst", "m32", "D9 /2", "X87"},
+	{"fst", "m64", "DD /2", "X87"},
+	{"fst", "st", "DD D0+r", "X87"},
+	{"fstp", "m32", "D9 /3", "X87"},
+	{"fstp", "m64", "DD /3", "X87"},
+	{"fstp", "m80", "DB /7", "X87"},
+	{"fstp", "st", "DD D8+r", "X87"},
+	{"fild", "m16", "DF /0", "X87"},
+	{"fild", "m32", "DB /0", "X87"},
+	{"fild", "m64", "DF /5", "X87"},
+	{"fist", "m16", "DF /2", "X87"},
+	{"fist", "m32", "DB /2", "X87"},
+	{"fistp", "m16", "DF /3", "X87"},
+	{"fistp", "m32", "DB /3", "X87"},
+	{"fistp", "m64", "DF /7", "X87"},
+	{"fisttp", "m16", "DF /1", "SSE3"},
+	{"fisttp", "m32", "DB /1", "SSE3"},
+	{"fisttp", "m64", "DD /1", "SSE3"},
+
+	{"fxch", "", "D9 C9", "X87"},
+	{"fxch", "st", "D9 C8+r", "X87"},
+	{"fxch", "st0, st", "D9 C8+r", "X87"},
+	{"fcomi", "st", "DB F0+r", "P6"},
+	{"fcomi", "st0, st", "DB F0+r", "P6"},
+	{"fcomip", "st", "DF F0+r", "P6"},
+	{"fcomip", "st0, st", "DF F0+r", "P6"},
+	{"fucomi", "st", "DB E8+r", "P6"},
+	{"fucomi", "st0, st", "DB E8+r", "P6"},
+	{"fucomip", "st", "DF E8+r", "P6"},
+	{"fucomip", "st0, st", "DF E8+r", "P6"},
+
+	{"fchs", "", "D9 E0", "X87"},
+	{"fabs", "", "D9 E1", "X87"},
+	{"fsqrt", "", "D9 FA", "X87"},
+	{"fld1", "", "D9 E8", "X87"},
+	{"fldz", "", "D9 EE", "X87"},
+	{"fldpi", "", "D9 EB", "X87"},
+
+	{"fwait", "", "9B", "X87"},
+	{"wait", "", "9B", "X87"},
+	{"finit", "", "wait DB E3", "X87"},
+	{"fninit", "", "DB E3", "X87"},
+	{"fldcw", "m16", "D9 /5", "X87"},
+	{"fstcw", "m16", "wait D9 /7", "X87"},
+	{"fnstcw", "m16", "D9 /7", "X87"},
+	{"fstsw", "ax", "wait DF E0", "X87"},
+	{"fstsw", "m16", "wait DD /7", "X87"},
+	{"fnstsw", "ax", "DF E0", "X87"},
+	{"fnstsw", "m16", "DD /7", "X87"},
+}
+
+// x87ArithTable generates the arithmetic instructions. Each has a memory
+// form, a form with st0 as the destination, a form with st(i) as the
+// destination and a popping form. The reversed subtract and divide swap
+// rows with their plain forms when st(i) is the destination.
+func x87ArithTable() []tableEntry {
+	var out []tableEntry
+	for _, op := range []struct {
+		name        string
+		digit       int
+		toST0, toST byte
+	}{
+		{"fadd", 0, 0xC0, 0xC0},
+		{"fmul", 1, 0xC8, 0xC8},
+		{"fsub", 4, 0xE0, 0xE8},
+		{"fsubr", 5, 0xE8, 0xE0},
+		{"fdiv", 6, 0xF0, 0xF8},
+		{"fdivr", 7, 0xF8, 0xF0},
+	} {
+		out = append(out,
+			tableEntry{op.name, "m32", fmt.Sprintf("D8 /%d", op.digit), "X87"},
+			tableEntry{op.name, "m64", fmt.Sprintf("DC /%d", op.digit), "X87"},
+			tableEntry{op.name, "st", fmt.Sprintf("D8 %02X+r", op.toST0), "X87"},
+			tableEntry{op.name, "st0, st", fmt.Sprintf("D8 %02X+r", op.toST0), "X87"},
+			tableEntry{op.name, "st, st0", fmt.Sprintf("DC %02X+r", op.toST), "X87"},
+			tableEntry{op.name + "p", "", fmt.Sprintf("DE %02X", op.toST+1), "X87"},
+			tableEntry{op.name + "p", "st, st0", fmt.Sprintf("DE %02X+r", op.toST), "X87"},
+			tableEntry{"fi" + op.name[1:], "m16", fmt.Sprintf("DE /%d", op.digit), "X87"},
+			tableEntry{"fi" + op.name[1:], "m32", fmt.Sprintf("DA /%d", op.digit), "X87"},
+		)
+	}
+	return out
+}
@@ -30,7 +30,136 @@ type AssemblyResult struct {
 	Sections []format.Section
 }
 
+const maxLayoutPasses = 100
+
 func (a *Assembler) Assemble(f *ast.File) (*AssemblyResult, error) {
+	labels, err := collectLabels(f)
+	if err != nil {
+		return nil, err
+	}
+
+	minSize := make(map[*ast.Instruction]int)
+	var prev map[string]format.Symbol
+
+	for pass := 1; pass <= maxLayoutPasses; pass++ {
+		result, syms, err := a.assemblePass(f, labels, prev, minSize)
+		if err != nil {
+			return nil, err
+		}
+		if prev != nil && sameLayout(prev, syms) {
+			for _, r := range result.Relocs {
+				if _, ok := labels[r.Name]; !ok {
+					return nil, fmt.Errorf("undefined symbol: %s", r.Name)
+				}
+			}
+			return result, nil
+		}
+		prev = syms
+	}
+
+	return nil, fmt.Errorf("layout did not converge after %d passes", maxLayoutPasses)
+}
+
+func collectLabels(f *ast.File) (map[string]string, error) {
+	labels := make(map[string]string)
+	currentSection := ".text"
+
+	for _, it := range f.Items {
+		switch n := it.(type) {
+		case *ast.Label:
+			if _, exists := labels[n.Name]; exists {
+				return nil, fmt.Errorf("duplicate label: %s", n.Name)
+			}
+			labels[n.Name] = currentSection
+		case *ast.Directive:
+			currentSection = sectionFromDirective(n, currentSection)
+		case *ast.DataDecl:
+			currentSection = ".data"
+		}
+	}
+
+	// Names declared by extern or global but not defined here are kept
+	// without a section, so references to them are not undefined.
+	for _, it := range f.Items {
+		d, ok := it.(*ast.Directive)
+		if !ok || (d.Name != "extern" && d.Name != "global") {
+			continue
+		}
+		for _, name := range d.Args {
+			if _, exists := labels[name]; !exists && name != "," {
+				labels[name] = ""
+			}
+		}
+	}
+	return labels, nil
+}
+
+func sectionFromDirective(d *ast.Directive, current string) string {
+	if len(d.Args) > 0 {
+		switch d.Args[0] {
+		case ".text", "text":
+			return ".text"
+		case ".data", "data":
+			return ".data"
+		}
+	}
+	return current
+}
+
+func defaultAddressing(d *ast.Directive) (bool, error) {
+	if len(d.Args) == 1 {
+		switch strings.ToLower(d.Args[0]) {
+		case "rel":
+			return true, nil
+		case "abs":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("line %d: default expects rel or abs", d.Line)
+}
+
+func codeBits(d *ast.Directive) (int, error) {
+	if len(d.Args) == 1 {
+		switch d.Args[0] {
+		case "16":
+			return 16, nil
+		case "32":
+			return 32, nil
+		case "64":
+			return 64, nil
+		}
+	}
+	return 0, fmt.Errorf("line %d: bits expects 16, 32 or 64", d.Line)
+}
+
+// dataReloc returns the size and relocation kind of an address stored by
+// a data declaration, which is as wide as its elements.
+func dataReloc(kind string) (int, arch.RelocKind, error) {
+	switch kind {
+	case "dw":
+		return 2, arch.RelocAbs16, nil
+	case "dd":
+		return 4, arch.RelocAbs32, nil
+	case "dq":
+		return 8, arch.RelocAbs64, nil
+	}
+	return 0, 0, fmt.Errorf("%s cannot hold an address", kind)
+}
+
+func sameLayout(a, b map[string]format.Symbol) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, sa := range a {
+		sb, ok := b[name]
+		if !ok || sa.Section != sb.Section || sa.Offset != sb.Offset {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *Assembler) assemblePass(f *ast.File, labels map[string]string, prev map[string]format.Symbol, minSize map[*ast.Instruction]int) (*AssemblyResult, map[string]format.Symbol, error) {
 	result := &AssemblyResult{}
 
 	var codeBuf bytes.Buffer
@@ -40,14 +169,33 @@ func (a *Assembler) Assemble(f *ast.File) (*AssemblyResult, error) {
 	var relocs []format.Reloc
 
 	currentSection := ".text"
+	defaultRel := false
+	bits := 0
+
+	lookup := func(name string) (arch.Symbol, bool) {
+		if s, ok := syms[name]; ok {
+			return arch.Symbol(s), true
+		}
+		if s, ok := prev[name]; ok {
+			return arch.Symbol(s), true
+		}
+		section, ok := labels[name]
+		if !ok {
+			return arch.Symbol{}, false
+		}
+		if section == "" {
+			return arch.Symbol{Name: name}, true
+		}
+		offset := uint64(codeBuf.Len())
+		if section != ".text" {
+			offset = uint64(dataBuf.Len())
+		}
+		return arch.Symbol{Name: name, Section: section, Offset: offset}, true
+	}
 
 	for _, it := range f.Items {
 		switch n := it.(type) {
 		case *ast.Label:
-			if _, exists := syms[n.Name]; exists {
-				return nil, fmt.Errorf("duplicate label: %s", n.Name)
-			}
-
 			var offset uint64
 			if currentSection == ".text" {
 				offset = uint64(codeBuf.Len())
@@ -63,20 +211,32 @@ func (a *Assembler) Assemble(f *ast.File) (*AssemblyResult, error) {
 			syms[n.Name] = sym
 
 		case *ast.Directive:
-			if len(n.Args) > 0 {
-				switch n.Args[0] {
-				case ".text", "text":
-					currentSection = ".text"
-				case ".data", "data":
-					currentSection = ".data"
+			currentSection = sectionFromDirective(n, currentSection)
+			if strings.ToLower(n.Name) == "default" {
+				rel, err := defaultAddressing(n)
+				if err != nil {
+					return nil, nil, err
 				}
+				defaultRel = rel
+			}
+			if strings.ToLower(n.Name) == "bits" {
+				b, err := codeBits(n)
+				if err != nil {
+					return nil, nil, err
+				}
+				bits = b
 			}
-
 		case *ast.DataDecl:
 			currentSection = ".data"
 			for _, item := range n.Items {
 				if item.IsStr {
 					dataBuf.WriteString(item.Str)
+				} else if n.Kind == "dt" || isFloat(item.Expr) {
+					b, err := encodeFloat(item.Expr, n.Kind)
+					if err != nil {
+						return nil, nil, fmt.Errorf("line %d: %v", n.Line, err)
+					}
+					dataBuf.Write(b)
 				} else {
 					switch v := item.Expr.(type) {
 					case ast.NumberExpr:
@@ -97,61 +257,51 @@ func (a *Assembler) Assemble(f *ast.File) (*AssemblyResult, error) {
 							dataBuf.Write(tmp[:])
 						}
 					case ast.IdentExpr:
+						size, kind, err := dataReloc(n.Kind)
+						if err != nil {
+							return nil, nil, fmt.Errorf("line %d: %v", n.Line, err)
+						}
 						relocs = append(relocs, format.Reloc{
 							Section: ".data",
 							Offset:  uint64(dataBuf.Len()),
-							Size:    8,
+							Size:    size,
 							Name:    v.Name,
-							Kind:    int(arch.RelocAbs64),
+							Kind:    int(kind),
+							Line:    n.Line,
 						})
-						dataBuf.Write(make([]byte, 8))
+						dataBuf.Write(make([]byte, size))
 					}
 				}
 			}
 
 		case *ast.Instruction:
 			if currentSection == ".text" {
-				code, err := a.encoder.EncodeInstruction(n)
+				ctx := &arch.Context{
+					PC:         uint64(codeBuf.Len()),
+					Section:    currentSection,
+					MinSize:    minSize[n],
+					DefaultRel: defaultRel,
+					Bits:       bits,
+					Lookup:     lookup,
+				}
+				code, fixups, err := a.encoder.EncodeInstruction(n, ctx)
 				if err != nil {
-					return nil, fmt.Errorf("line %d: %v", n.Line, err)
+					return nil, nil, fmt.Errorf("line %d: %v", n.Line, err)
+				}
+				if len(code) > minSize[n] {
+					minSize[n] = len(code)
 				}
 
-				for _, op := range n.Operands {
-					if lbl, ok := op.(ast.LabelOperand); ok {
-						mn := strings.ToLower(n.Mnemonic)
-						if mn == "jmp" || strings.HasPrefix(mn, "j") || mn == "call" {
-							relocs = append(relocs, format.Reloc{
-								Section: ".text",
-								Offset:  uint64(codeBuf.Len() + 1),
-								Size:    4,
-								Name:    lbl.Name,
-								Kind:    int(arch.RelocRel32),
-							})
-						}
-					}
-					if imm, ok := op.(ast.ImmOperand); ok {
-						if ident, ok := imm.Val.(ast.IdentExpr); ok {
-							relocs = append(relocs, format.Reloc{
-								Section: ".text",
-								Offset:  uint64(codeBuf.Len() + 2),
-								Size:    8,
-								Name:    ident.Name,
-								Kind:    int(arch.RelocAbs64),
-							})
-						}
-					}
-					if lbl, ok := op.(ast.LabelOperand); ok {
-						mn := strings.ToLower(n.Mnemonic)
-						if mn == "mov" {
-							relocs = append(relocs, format.Reloc{
-								Section: ".text",
-								Offset:  uint64(codeBuf.Len() + 2),
-								Size:    8,
-								Name:    lbl.Name,
-								Kind:    int(arch.RelocAbs64),
-							})
-						}
-					}
+				for _, fx := range fixups {
+					relocs = append(relocs, format.Reloc{
+						Section: currentSection,
+						Offset:  uint64(codeBuf.Len() + fx.Offset),
+						Size:    fx.Size,
+						Name:    fx.Symbol,
+						Addend:  fx.Addend,
+						Kind:    int(fx.Kind),
+						Line:    n.Line,
+					})
 				}
 
 				codeBuf.Write(code)
@@ -172,7 +322,7 @@ func (a *Assembler) Assemble(f *ast.File) (*AssemblyResult, error) {
 		{Name: ".data", Data: result.Data},
 	}
 
-	return result, nil
+	return result, syms, nil
 }
 
 func (a *Assembler) BuildBinary(result *AssemblyResult, outputPath string) ([]byte, error) {
@@ -197,6 +347,19 @@ func (a *Assembler) BuildBinary(result *AssemblyResult, outputPath string) ([]by
 	textVaddr := baseVaddr + textFileOff
 	dataVaddr := textVaddr + uint64(len(result.Code))
 
+	codeStart := textFileOff
+	codeEnd := codeStart + uint64(len(result.Code))
+	dataEnd := codeEnd + uint64(len(result.Data))
+
+	if uint64(len(bin)) < dataEnd {
+		newBin := make([]byte, dataEnd)
+		copy(newBin, bin)
+		bin = newBin
+	}
+
+	copy(bin[codeStart:codeEnd], result.Code)
+	copy(bin[codeEnd:dataEnd], result.Data)
+
 	for _, r := range result.Relocs {
 		var targetAddr uint64
 		for _, s := range result.Symbols {
@@ -215,6 +378,11 @@ func (a *Assembler) BuildBinary(result *AssemblyResult, outputPath string) ([]by
 		}
 
 		targetAddrWithAdd := uint64(int64(targetAddr) + r.Addend)
+		if arch.RelocKind(r.Kind) != arch.RelocRel32 {
+			if err := format.CheckAbsolute(r, int64(targetAddrWithAdd), a.encoder.WordSize()); err != nil {
+				return nil, err
+			}
+		}
 
 		if r.Section == ".text" {
 			offset := textFileOff + r.Offset
@@ -227,6 +395,8 @@ func (a *Assembler) BuildBinary(result *AssemblyResult, outputPath string) ([]by
 				binary.LittleEndian.PutUint64(bin[offset:offset+8], targetAddrWithAdd)
 			case arch.RelocAbs32:
 				binary.LittleEndian.PutUint32(bin[offset:offset+4], uint32(targetAddrWithAdd))
+			case arch.RelocAbs16:
+				binary.LittleEndian.PutUint16(bin[offset:offset+2], uint16(targetAddrWithAdd))
 			case arch.RelocRel32:
 				rel := int64(targetAddr) - int64(textVaddr) - int64(r.Offset) - 4 + r.Addend
 				binary.LittleEndian.PutUint32(bin[offset:offset+4], uint32(int32(rel)))
@@ -241,22 +411,11 @@ func (a *Assembler) BuildBinary(result *AssemblyResult, outputPath string) ([]by
 				binary.LittleEndian.PutUint64(bin[offset:offset+8], targetAddrWithAdd)
 			} else if r.Size == 4 {
 				binary.LittleEndian.PutUint32(bin[offset:offset+4], uint32(targetAddrWithAdd))
+			} else if r.Size == 2 {
+				binary.LittleEndian.PutUint16(bin[offset:offset+2], uint16(targetAddrWithAdd))
 			}
 		}
 	}
 
-	codeStart := textFileOff
-	codeEnd := codeStart + uint64(len(result.Code))
-	dataEnd := codeEnd + uint64(len(result.Data))
-
-	if uint64(len(bin)) < dataEnd {
-		newBin := make([]byte, dataEnd)
-		copy(newBin, bin)
-		bin = newBin
-	}
-
-	copy(bin[codeStart:codeEnd], result.Code)
-	copy(bin[codeEnd:dataEnd], result.Data)
-
 	return bin, nil
 }
@@ -0,0 +1,147 @@
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gasm/internal/arch"
+	"gasm/internal/arch/x86_64"
+	"gasm/internal/format/elf"
+	"gasm/internal/parser"
+	"strings"
+	"testing"
+)
+
+func TestBranchRelaxation(t *testing.T) {
+	// The first jump stays short; the second grows once the nops
+	// push its target out of rel8 range.
+	src := "_start:\njmp short_target\nnop\nshort_target:\njmp long_target\n" +
+		strings.Repeat("nop\n", 130) + "long_target:\nret\n"
+	result, err := assemble(t, x86_64.NewEncoder(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("% x", result.Code[:8]); got != "eb 01 90 e9 82 00 00 00" {
+		t.Errorf("got %s, want eb 01 90 e9 82 00 00 00", got)
+	}
+	if len(result.Code) != 139 {
+		t.Errorf("got %d bytes of code, want 139", len(result.Code))
+	}
+}
+
+func TestBuildBinaryPatchesFixups(t *testing.T) {
+	// je done needs rel32 across the movs, jmp next keeps rel8 and
+	// jmp _start is a backward rel32; mov rax, msg holds the address
+	// of data laid out after the code.
+	src := "_start:\nmov rax, msg\nje done\njmp next\nnext:\n" +
+		strings.Repeat("mov rax, rbx\n", 50) +
+		"done:\njmp _start\nsection .data\nmsg:\ndb 1\n"
+	a, result := assembleForBuild(t, x86_64.NewEncoder(), src)
+	if len(result.Code) != 173 {
+		t.Fatalf("got %d bytes of code, want 173", len(result.Code))
+	}
+	bin, err := a.BuildBinary(result, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := bin[0x1000:]
+	if got := binary.LittleEndian.Uint64(text[2:]); got != 0x4010ad {
+		t.Errorf("mov rax, msg: got address %#x, want 0x4010ad", got)
+	}
+	if got := fmt.Sprintf("% x", text[10:18]); got != "0f 84 98 00 00 00 eb 00" {
+		t.Errorf("je done; jmp next: got %s, want 0f 84 98 00 00 00 eb 00", got)
+	}
+	if got := fmt.Sprintf("% x", text[168:174]); got != "e9 53 ff ff ff 01" {
+		t.Errorf("jmp _start; db 1: got %s, want e9 53 ff ff ff 01", got)
+	}
+}
+
+func TestExternSymbolsAreNotUndefined(t *testing.T) {
+	src := "extern puts\nglobal _start, helper\n_start:\ncall puts\nmov rax, helper\n"
+	if _, err := assemble(t, x86_64.NewEncoder(), src); err != nil {
+		t.Errorf("extern puts: %v", err)
+	}
+	src = "_start:\nmov rax, puts\n"
+	if _, err := assemble(t, x86_64.NewEncoder(), src); err == nil || !strings.Contains(err.Error(), "undefined symbol: puts") {
+		t.Errorf("got error %v, want undefined symbol: puts", err)
+	}
+}
+
+var floatDataTests = []struct{ src, want string }{
+	{"dd 1.5", "00 00 c0 3f"},
+	{"dq -2.0", "00 00 00 00 00 00 00 c0"},
+	{"dt 1.0", "00 00 00 00 00 00 00 80 ff 3f"},
+	{"dt 0.1", "cd cc cc cc cc cc cc cc fb 3f"},
+	{"dd -0.0", "00 00 00 80"},
+	{"dq -0.0", "00 00 00 00 00 00 00 80"},
+	{"dt -0.0", "00 00 00 00 00 00 00 00 00 80"},
+	{"dd 1.0e-45", "01 00 00 00"},
+	{"dq 4.9e-324", "01 00 00 00 00 00 00 00"},
+	{"dt 3.6e-4951", "01 00 00 00 00 00 00 00 00 00"},
+	{"dt 1.0e-4940", "62 8e 27 63 06 00 00 00 00 00"},
+	{"dd __Infinity__", "00 00 80 7f"},
+	{"dq -__Infinity__", "00 00 00 00 00 00 f0 ff"},
+	{"dt __Infinity__", "00 00 00 00 00 00 00 80 ff 7f"},
+	{"dt -__Infinity__", "00 00 00 00 00 00 00 80 ff ff"},
+}
+
+func TestFloatData(t *testing.T) {
+	for _, tt := range floatDataTests {
+		result, err := assemble(t, x86_64.NewEncoder(), "section .data\n"+tt.src+"\n")
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got := fmt.Sprintf("% x", result.Data); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestAddressMustFitField(t *testing.T) {
+	// msg is at 0x401000 or above, which a 16-bit field cannot hold.
+	src := "_start:\nret\nsection .data\nmsg:\ndw msg\n"
+	a, result := assembleForBuild(t, x86_64.NewEncoder(), src)
+	if _, err := a.BuildBinary(result, ""); err == nil || !strings.Contains(err.Error(), "does not fit in 16 bits") {
+		t.Errorf("got error %v, want address does not fit in 16 bits", err)
+	}
+}
+
+func TestDataAddressSize(t *testing.T) {
+	src := "_start:\nret\nsection .data\nmsg:\ndd msg\n"
+	a, result := assembleForBuild(t, x86_64.NewX86Encoder(), src)
+	bin, err := a.BuildBinary(result, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("% x", bin[0x1001:]); got != "01 10 40 00" {
+		t.Errorf("dd msg: got %s, want 01 10 40 00", got)
+	}
+	if _, err := assemble(t, x86_64.NewX86Encoder(), "section .data\nmsg:\ndb msg\n"); err == nil || !strings.Contains(err.Error(), "db cannot hold an address") {
+		t.Errorf("db msg: got error %v, want db cannot hold an address", err)
+	}
+}
+
+func assemble(t *testing.T, e arch.Encoder, src string) (*AssemblyResult, error) {
+	t.Helper()
+	p := parser.New(strings.NewReader(src))
+	f := p.ParseFile()
+	if len(p.Errors) > 0 {
+		t.Fatalf("parse errors %v", p.Errors)
+	}
+	return NewAssembler(e, elf.NewBuilder(e.Arch())).Assemble(f)
+}
+
+func assembleForBuild(t *testing.T, e arch.Encoder, src string) (*Assembler, *AssemblyResult) {
+	t.Helper()
+	p := parser.New(strings.NewReader(src))
+	f := p.ParseFile()
+	if len(p.Errors) > 0 {
+		t.Fatalf("parse errors %v", p.Errors)
+	}
+	a := NewAssembler(e, elf.NewBuilder(e.Arch()))
+	result, err := a.Assemble(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, result
+}
@@ -0,0 +1,120 @@
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gasm/internal/ast"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// infinity is NASM's name for the floating point infinity constant.
+const infinity = "__Infinity__"
+
+func isFloat(e ast.Expr) bool {
+	if u, ok := e.(ast.UnaryExpr); ok {
+		e = u.X
+	}
+	switch v := e.(type) {
+	case ast.FloatExpr:
+		return true
+	case ast.IdentExpr:
+		return v.Name == infinity
+	}
+	return false
+}
+
+// encodeFloat returns the bytes of a floating point data item: single
+// precision for dd, double for dq and x87 extended precision for dt.
+// dt also accepts integers, which are converted exactly.
+func encodeFloat(e ast.Expr, kind string) ([]byte, error) {
+	neg := false
+	if u, ok := e.(ast.UnaryExpr); ok {
+		neg, e = u.Op == "-", u.X
+	}
+	var lit string
+	switch v := e.(type) {
+	case ast.FloatExpr:
+		lit = v.Lit
+	case ast.NumberExpr:
+		lit = strconv.FormatInt(v.Val, 10)
+	case ast.IdentExpr:
+		if v.Name != infinity {
+			return nil, fmt.Errorf("%s expects a numeric constant", kind)
+		}
+		lit = "Inf"
+	default:
+		return nil, fmt.Errorf("%s expects a numeric constant", kind)
+	}
+	if neg {
+		lit = "-" + lit
+	}
+
+	switch kind {
+	case "dd":
+		f, err := strconv.ParseFloat(lit, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %s", lit)
+		}
+		return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(f))), nil
+	case "dq":
+		f, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %s", lit)
+		}
+		return binary.LittleEndian.AppendUint64(nil, math.Float64bits(f)), nil
+	case "dt":
+		f, _, err := big.ParseFloat(lit, 10, 64, big.ToNearestEven)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %s", lit)
+		}
+		return float80(f, lit)
+	}
+	return nil, fmt.Errorf("%s cannot hold a floating point value", kind)
+}
+
+// float80 encodes x in the x87 extended format: a sign bit, a 15-bit
+// exponent biased by 16383 and a 64-bit mantissa with an explicit
+// integer bit. Values below the smallest normal are encoded as denormals
+// with a zero exponent, rounding lit again to the bits that remain.
+func float80(x *big.Float, lit string) ([]byte, error) {
+	out := make([]byte, 10)
+	var se uint16
+	if x.Signbit() {
+		se = 0x8000
+	}
+	var m uint64
+	switch {
+	case x.IsInf():
+		se |= 0x7FFF
+		m = 1 << 63
+	case x.Sign() != 0:
+		var mant big.Float
+		exp := x.MantExp(&mant) - 1 + 16383
+		if exp >= 0x7FFF {
+			return nil, fmt.Errorf("%s is out of range for an 80-bit float", x.Text('g', 10))
+		}
+		if exp > 0 {
+			m, _ = mant.Abs(&mant).SetMantExp(&mant, 64).Uint64()
+			se |= uint16(exp)
+			break
+		}
+		// A denormal is m * 2^-16445 with exp+63 significant bits in m.
+		if prec := exp + 63; prec > 0 {
+			x, _, _ = big.ParseFloat(lit, 10, uint(prec), big.ToNearestEven)
+		}
+		var d big.Float
+		m, _ = d.Abs(x).SetMantExp(&d, 16445).Uint64()
+		if exp+63 <= 0 && d.Cmp(big.NewFloat(0.5)) > 0 {
+			m = 1
+		}
+		if m == 1<<63 {
+			// Rounding carried into the smallest normal number.
+			se |= 1
+		}
+	}
+	binary.LittleEndian.PutUint64(out, m)
+	binary.LittleEndian.PutUint16(out[8:], se)
+	return out, nil
+}
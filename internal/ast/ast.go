@@ -29,6 +29,7 @@ func (d *Directive) node()           {}
 func (d *Directive) Pos() (int, int) { return d.Line, d.Col }
 
 type Instruction struct {
+	Prefixes []string
 	Mnemonic string
 	Operands []Operand
 	Line     int
@@ -78,26 +79,53 @@ type ExprOrString struct {
 	IsStr bool
 }
 
-type RegOperand struct{ Name string }
+type RegOperand struct {
+	Name string
+	Mask string
+	Zero bool
+}
 
 func (RegOperand) operand() {}
 
-type ImmOperand struct{ Val Expr }
+// ImmOperand is an immediate. Size is the width of its field when given
+// with a size keyword, as in add eax, byte 5.
+type ImmOperand struct {
+	Val  Expr
+	Size int
+}
 
 func (ImmOperand) operand() {}
 
+// FarOperand is a segment:offset pair, as in jmp 0x08:start.
+type FarOperand struct {
+	Seg  Expr
+	Off  Expr
+	Size int
+}
+
+func (FarOperand) operand() {}
+
 type MemOperand struct {
 	Base  string
 	Index string
 	Scale int
 	Disp  Expr
 	Size  int
+	Rel   bool
+	Abs   bool
+	Seg   string
+	Mask  string
+	Bcst  int
 	Line  int
 	Col   int
 }
 
 func (MemOperand) operand() {}
 
+type RoundingOperand struct{ Mode string }
+
+func (RoundingOperand) operand() {}
+
 type LabelOperand struct{ Name string }
 
 func (LabelOperand) operand() {}
@@ -112,6 +140,12 @@ type NumberExpr struct{ Val int64 }
 
 func (NumberExpr) expr() {}
 
+// FloatExpr is a floating point literal, kept as written so that it can
+// be rounded once to the precision of its destination.
+type FloatExpr struct{ Lit string }
+
+func (FloatExpr) expr() {}
+
 type IdentExpr struct{ Name string }
 
 func (IdentExpr) expr() {}
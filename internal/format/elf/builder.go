@@ -2,6 +2,7 @@ package elf
 
 import (
 	"encoding/binary"
+	"fmt"
 	"gasm/internal/arch"
 	"gasm/internal/format"
 )
@@ -71,8 +72,12 @@ func BuildELF(codeLen, dataLen uint64, wordSize int, archID int, symbols []forma
 	const pageSize = uint64(0x1000)
 	const baseVaddr = uint64(0x400000)
 
-	ehSize := uint64(64)
-	phSize := uint64(56)
+	for _, r := range relocs {
+		if !supportedReloc(archID, r.Kind) {
+			return nil, fmt.Errorf("%s: %d-byte relocation is not supported on %s", r.Name, r.Size, arch.Arch(archID))
+		}
+	}
+
 	textFileOff := pageSize
 
 	payloadSize := codeLen + dataLen
@@ -96,6 +101,19 @@ func BuildELF(codeLen, dataLen uint64, wordSize int, archID int, symbols []forma
 	binary.LittleEndian.PutUint32(buf[20:], 1)
 
 	entry := baseVaddr + textFileOff
+	if wordSize == 8 {
+		writeHeaders64(buf, entry, textFileOff, payloadSize, pageSize)
+	} else {
+		writeHeaders32(buf, entry, textFileOff, payloadSize, pageSize)
+	}
+
+	return buf, nil
+}
+
+func writeHeaders64(buf []byte, entry, textFileOff, payloadSize, pageSize uint64) {
+	ehSize := uint64(64)
+	phSize := uint64(56)
+
 	binary.LittleEndian.PutUint64(buf[24:], entry)
 	binary.LittleEndian.PutUint64(buf[32:], ehSize)
 	binary.LittleEndian.PutUint64(buf[40:], 0)
@@ -111,13 +129,52 @@ func BuildELF(codeLen, dataLen uint64, wordSize int, archID int, symbols []forma
 	binary.LittleEndian.PutUint32(buf[phoff+0:], 1)
 	binary.LittleEndian.PutUint32(buf[phoff+4:], 7)
 	binary.LittleEndian.PutUint64(buf[phoff+8:], textFileOff)
-	binary.LittleEndian.PutUint64(buf[phoff+16:], baseVaddr+textFileOff)
-	binary.LittleEndian.PutUint64(buf[phoff+24:], baseVaddr+textFileOff)
+	binary.LittleEndian.PutUint64(buf[phoff+16:], entry)
+	binary.LittleEndian.PutUint64(buf[phoff+24:], entry)
 	binary.LittleEndian.PutUint64(buf[phoff+32:], payloadSize)
 	binary.LittleEndian.PutUint64(buf[phoff+40:], payloadSize)
 	binary.LittleEndian.PutUint64(buf[phoff+48:], pageSize)
+}
 
-	return buf, nil
+// writeHeaders32 writes the Elf32_Ehdr fields after e_version and a single
+// Elf32_Phdr, whose fields are 4 bytes wide and ordered differently from
+// the 64-bit layout.
+func writeHeaders32(buf []byte, entry, textFileOff, payloadSize, pageSize uint64) {
+	ehSize := uint64(52)
+	phSize := uint64(32)
+
+	binary.LittleEndian.PutUint32(buf[24:], uint32(entry))
+	binary.LittleEndian.PutUint32(buf[28:], uint32(ehSize))
+	binary.LittleEndian.PutUint32(buf[32:], 0)
+	binary.LittleEndian.PutUint32(buf[36:], 0)
+	binary.LittleEndian.PutUint16(buf[40:], uint16(ehSize))
+	binary.LittleEndian.PutUint16(buf[42:], uint16(phSize))
+	binary.LittleEndian.PutUint16(buf[44:], 1)
+	binary.LittleEndian.PutUint16(buf[46:], 0)
+	binary.LittleEndian.PutUint16(buf[48:], 0)
+	binary.LittleEndian.PutUint16(buf[50:], 0)
+
+	phoff := ehSize
+	binary.LittleEndian.PutUint32(buf[phoff+0:], 1)
+	binary.LittleEndian.PutUint32(buf[phoff+4:], uint32(textFileOff))
+	binary.LittleEndian.PutUint32(buf[phoff+8:], uint32(entry))
+	binary.LittleEndian.PutUint32(buf[phoff+12:], uint32(entry))
+	binary.LittleEndian.PutUint32(buf[phoff+16:], uint32(payloadSize))
+	binary.LittleEndian.PutUint32(buf[phoff+20:], uint32(payloadSize))
+	binary.LittleEndian.PutUint32(buf[phoff+24:], 7)
+	binary.LittleEndian.PutUint32(buf[phoff+28:], uint32(pageSize))
+}
+
+// supportedReloc reports whether the target can hold a relocation of the
+// given kind. 32-bit x86 has no 64-bit absolute relocation.
+func supportedReloc(archID int, kind int) bool {
+	switch arch.RelocKind(kind) {
+	case arch.RelocAbs32, arch.RelocAbs16, arch.RelocRel32:
+		return arch.Arch(archID) == arch.ArchX86 || arch.Arch(archID) == arch.ArchX86_64
+	case arch.RelocAbs64:
+		return arch.Arch(archID) == arch.ArchX86_64
+	}
+	return false
 }
 
 func machineFromArch(archID int) uint16 {
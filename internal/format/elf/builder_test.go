@@ -0,0 +1,46 @@
+package elf
+
+import (
+	"encoding/binary"
+	"gasm/internal/arch"
+	"gasm/internal/format"
+	"testing"
+)
+
+func TestBuildELF32Headers(t *testing.T) {
+	buf, err := BuildELF(4, 0, 4, int(arch.ArchX86), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf[4] != 1 {
+		t.Errorf("EI_CLASS: got %d, want 1 (ELFCLASS32)", buf[4])
+	}
+	le := binary.LittleEndian
+	for _, f := range []struct {
+		name      string
+		got, want uint32
+	}{
+		{"e_machine", uint32(le.Uint16(buf[18:])), 3},
+		{"e_entry", le.Uint32(buf[24:]), 0x401000},
+		{"e_phoff", le.Uint32(buf[28:]), 52},
+		{"e_ehsize", uint32(le.Uint16(buf[40:])), 52},
+		{"e_phentsize", uint32(le.Uint16(buf[42:])), 32},
+		{"p_offset", le.Uint32(buf[52+4:]), 0x1000},
+		{"p_filesz", le.Uint32(buf[52+16:]), 4},
+		{"p_flags", le.Uint32(buf[52+24:]), 7},
+	} {
+		if f.got != f.want {
+			t.Errorf("%s: got %#x, want %#x", f.name, f.got, f.want)
+		}
+	}
+}
+
+func TestBuildELFRejectsAbs64OnX86(t *testing.T) {
+	relocs := []format.Reloc{{Section: ".data", Size: 8, Name: "msg", Kind: int(arch.RelocAbs64)}}
+	if _, err := BuildELF(1, 8, 4, int(arch.ArchX86), nil, relocs); err == nil {
+		t.Error("BuildELF accepted an 8-byte relocation on x86")
+	}
+	if _, err := BuildELF(1, 8, 8, int(arch.ArchX86_64), nil, relocs); err != nil {
+		t.Errorf("x86_64: %v", err)
+	}
+}
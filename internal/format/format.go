@@ -1,5 +1,10 @@
 package format
 
+import (
+	"fmt"
+	"math"
+)
+
 type Format int
 
 const (
@@ -55,6 +60,28 @@ type Reloc struct {
 	Name    string
 	Addend  int64
 	Kind    int
+	Line    int
+}
+
+// CheckAbsolute reports an error if an absolute address does not fit in
+// the relocated field. A 16-bit field holds 0..0xFFFF and a 32-bit one
+// 0..0xFFFFFFFF, or a sign-extended value when the output is 64-bit.
+func CheckAbsolute(r Reloc, addr int64, wordSize int) error {
+	var lo, hi int64
+	switch {
+	case r.Size == 2:
+		lo, hi = 0, 0xFFFF
+	case r.Size == 4 && wordSize == 8:
+		lo, hi = math.MinInt32, math.MaxInt32
+	case r.Size == 4:
+		lo, hi = 0, math.MaxUint32
+	default:
+		return nil
+	}
+	if addr < lo || addr > hi {
+		return fmt.Errorf("line %d: address of %s (%#x) does not fit in %d bits", r.Line, r.Name, addr, r.Size*8)
+	}
+	return nil
 }
 
 type Section struct {
@@ -29,6 +29,8 @@ const (
 	TOK_PERCENT
 	TOK_DOT
 	TOK_HASH
+	TOK_LBRACE
+	TOK_RBRACE
 	TOK_OTHER
 )
 
@@ -53,6 +55,8 @@ func (k TokenKind) String() string {
 		TOK_PERCENT: "%",
 		TOK_DOT:     ".",
 		TOK_HASH:    "#",
+		TOK_LBRACE:  "{",
+		TOK_RBRACE:  "}",
 		TOK_OTHER:   "OTHER",
 	}
 	if v, ok := s[k]; ok {
@@ -198,7 +202,10 @@ func (lx *Lexer) NextToken() Token {
 				if err != nil {
 					break
 				}
-				if !(unicode.IsDigit(r2) || (r2 >= 'a' && r2 <= 'f') || (r2 >= 'A' && r2 <= 'F') || r2 == 'x' || r2 == 'b' || r2 == 'o' || r2 == 'h' || r2 == '.') {
+				// The exponent of a float literal may be signed, as in 1.0e-45.
+				lit := sb.String()
+				signedExp := (r2 == '+' || r2 == '-') && strings.Contains(lit, ".") && strings.ContainsAny(lit[len(lit)-1:], "eE")
+				if !(unicode.IsDigit(r2) || (r2 >= 'a' && r2 <= 'f') || (r2 >= 'A' && r2 <= 'F') || r2 == 'x' || r2 == 'b' || r2 == 'o' || r2 == 'h' || r2 == '.' || signedExp) {
 					lx.unread(r2)
 					break
 				}
@@ -234,6 +241,10 @@ func (lx *Lexer) NextToken() Token {
 			return Token{Kind: TOK_DOT, Lit: ".", Line: lx.line, Col: lx.col}
 		case '#':
 			return Token{Kind: TOK_HASH, Lit: "#", Line: lx.line, Col: lx.col}
+		case '{':
+			return Token{Kind: TOK_LBRACE, Lit: "{", Line: lx.line, Col: lx.col}
+		case '}':
+			return Token{Kind: TOK_RBRACE, Lit: "}", Line: lx.line, Col: lx.col}
 		default:
 			return Token{Kind: TOK_OTHER, Lit: string(r), Line: lx.line, Col: lx.col}
 		}
@@ -61,7 +61,13 @@ func (p *Parser) ParseFile() *ast.File {
 				p.consumeLine()
 				continue
 			}
-			p.backup(next)
+			if next.Kind == lexer.TOK_IDENT && isDataDirective(next.Lit) {
+				// A label before a data directive may omit its colon.
+				f.Items = append(f.Items, &ast.Label{Name: t.Lit, Line: t.Line, Col: t.Col})
+				t = next
+			} else {
+				p.backup(next)
+			}
 
 			node := p.parseStatementStartingWithIdent(t)
 			if node != nil {
@@ -77,6 +83,23 @@ func (p *Parser) ParseFile() *ast.File {
 			continue
 		}
 
+		if t.Kind == lexer.TOK_LBRACK {
+			// NASM's primitive form of a directive, as in [bits 16].
+			name := p.expect(lexer.TOK_IDENT)
+			var args []string
+			for t := p.next(); t.Kind != lexer.TOK_RBRACK; t = p.next() {
+				if t.Kind == lexer.TOK_NEWLINE || t.Kind == lexer.TOK_EOF {
+					p.Errors = append(p.Errors, fmt.Sprintf("expected ] at line %d", t.Line))
+					p.backup(t)
+					break
+				}
+				args = append(args, t.Lit)
+			}
+			f.Items = append(f.Items, &ast.Directive{Name: strings.ToLower(name.Lit), Args: args, Line: t.Line, Col: t.Col})
+			p.consumeLine()
+			continue
+		}
+
 		if t.Kind == lexer.TOK_DOT {
 			next := p.expect(lexer.TOK_IDENT)
 			name := "." + next.Lit
@@ -114,10 +137,10 @@ func (p *Parser) collectRestOfLineTokens() []string {
 func (p *Parser) parseStatementStartingWithIdent(first lexer.Token) ast.Node {
 	lit := strings.ToLower(first.Lit)
 	switch lit {
-	case "section", "global", "extern", "bits", "org", "align":
+	case "section", "global", "extern", "bits", "default", "org", "align":
 		args := p.collectRestOfLineTokens()
 		return &ast.Directive{Name: lit, Args: args, Line: first.Line, Col: first.Col}
-	case "db", "dw", "dd", "dq", "resb", "resw", "resd":
+	case "db", "dw", "dd", "dq", "dt", "resb", "resw", "resd":
 		items := p.parseDataItems()
 		return &ast.DataDecl{Kind: lit, Items: items, Line: first.Line, Col: first.Col}
 	case "%macro":
@@ -133,6 +156,15 @@ func (p *Parser) parseStatementStartingWithIdent(first lexer.Token) ast.Node {
 		return &ast.IfBlock{Cond: cond, Then: then, Line: first.Line, Col: first.Col}
 	default:
 		ins := &ast.Instruction{Mnemonic: first.Lit, Line: first.Line, Col: first.Col}
+		for isPrefix(ins.Mnemonic) {
+			t := p.next()
+			if t.Kind != lexer.TOK_IDENT {
+				p.backup(t)
+				break
+			}
+			ins.Prefixes = append(ins.Prefixes, strings.ToLower(ins.Mnemonic))
+			ins.Mnemonic = t.Lit
+		}
 		ops := p.parseOperands()
 		ins.Operands = ops
 		return ins
@@ -238,9 +270,15 @@ func (p *Parser) parseOperands() []ast.Operand {
 			continue
 		}
 
-		if t.Kind == lexer.TOK_NUMBER {
+		if t.Kind == lexer.TOK_NUMBER || t.Kind == lexer.TOK_MINUS || t.Kind == lexer.TOK_PLUS || t.Kind == lexer.TOK_LPAREN {
 			p.backup(t)
 			expr := p.parseExpr()
+			n := p.next()
+			if n.Kind == lexer.TOK_COLON {
+				ops = append(ops, ast.FarOperand{Seg: expr, Off: p.parseExpr()})
+				continue
+			}
+			p.backup(n)
 			ops = append(ops, ast.ImmOperand{Val: expr})
 			continue
 		}
@@ -259,16 +297,67 @@ func (p *Parser) parseOperands() []ast.Operand {
 			continue
 		}
 		if t.Kind == lexer.TOK_LBRACK {
-			inner := p.readUntilMatchingBracket()
-			exprp := New(strings.NewReader(inner))
-			ex := exprp.parseExpr()
-			mem := &ast.MemOperand{Disp: ex}
+			mem := p.parseMemOperand(t)
+			p.decorateMem(&mem, p.parseDecorators())
 			ops = append(ops, mem)
 			continue
 		}
+		if t.Kind == lexer.TOK_LBRACE {
+			p.backup(t)
+			if op, ok := p.parseRounding(t.Line); ok {
+				ops = append(ops, op)
+			}
+			continue
+		}
 		if t.Kind == lexer.TOK_IDENT {
+			if size, ok := sizeSpecifier(t.Lit); ok {
+				n := p.next()
+				p.backup(n)
+				if n.Kind == lexer.TOK_NUMBER || n.Kind == lexer.TOK_MINUS || n.Kind == lexer.TOK_PLUS || n.Kind == lexer.TOK_LPAREN {
+					expr := p.parseExpr()
+					c := p.next()
+					if c.Kind == lexer.TOK_COLON {
+						ops = append(ops, ast.FarOperand{Seg: expr, Off: p.parseExpr(), Size: size})
+						continue
+					}
+					p.backup(c)
+					ops = append(ops, ast.ImmOperand{Val: expr, Size: size})
+					continue
+				}
+				if mem, ok := p.parseSizedMemOperand(t, size); ok {
+					p.decorateMem(&mem, p.parseDecorators())
+					ops = append(ops, mem)
+				}
+				continue
+			}
+			if strings.EqualFold(t.Lit, "st") {
+				ops = append(ops, ast.RegOperand{Name: p.parseStackRegister(t)})
+				continue
+			}
+			if isSegmentRegister(t.Lit) {
+				n := p.next()
+				if n.Kind == lexer.TOK_COLON {
+					mem := p.parseMemOperand(p.expect(lexer.TOK_LBRACK))
+					p.overrideSegment(&mem, strings.ToLower(t.Lit))
+					p.decorateMem(&mem, p.parseDecorators())
+					ops = append(ops, mem)
+					continue
+				}
+				p.backup(n)
+			}
 			if isRegister(t.Lit) {
-				ops = append(ops, ast.RegOperand{Name: t.Lit})
+				reg := ast.RegOperand{Name: t.Lit}
+				p.decorateReg(&reg, p.parseDecorators(), t.Line)
+				ops = append(ops, reg)
+				continue
+			}
+
+			n := p.next()
+			p.backup(n)
+			switch n.Kind {
+			case lexer.TOK_PLUS, lexer.TOK_MINUS, lexer.TOK_STAR, lexer.TOK_SLASH:
+				expr := p.continueExprLevel1(p.continueExprLevel2(ast.IdentExpr{Name: t.Lit}))
+				ops = append(ops, ast.ImmOperand{Val: expr})
 				continue
 			}
 
@@ -280,38 +369,322 @@ func (p *Parser) parseOperands() []ast.Operand {
 	return ops
 }
 
-func (p *Parser) readUntilMatchingBracket() string {
-	var sb strings.Builder
-	depth := 1
+func sizeSpecifier(s string) (int, bool) {
+	switch strings.ToLower(s) {
+	case "byte":
+		return 1, true
+	case "word":
+		return 2, true
+	case "dword":
+		return 4, true
+	case "qword":
+		return 8, true
+	case "tword":
+		return 10, true
+	case "oword", "xmmword":
+		return 16, true
+	case "yword", "ymmword":
+		return 32, true
+	case "zword", "zmmword":
+		return 64, true
+	}
+	return 0, false
+}
+
+func (p *Parser) parseSizedMemOperand(spec lexer.Token, size int) (ast.MemOperand, bool) {
+	t := p.next()
+	if t.Kind == lexer.TOK_IDENT && strings.ToLower(t.Lit) == "ptr" {
+		t = p.next()
+	}
+	seg := ""
+	if t.Kind == lexer.TOK_IDENT && isSegmentRegister(t.Lit) {
+		seg = p.parseSegmentOverride(t)
+		t = p.next()
+	}
+	if t.Kind != lexer.TOK_LBRACK {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected memory operand after %s but got %s (%s) at line %d", spec.Lit, t.Kind, t.Lit, t.Line))
+		p.backup(t)
+		return ast.MemOperand{}, false
+	}
+	mem := p.parseMemOperand(t)
+	mem.Size = size
+	if seg != "" {
+		p.overrideSegment(&mem, seg)
+	}
+	return mem, true
+}
+
+// parseSegmentOverride reads the colon after a segment register written
+// as an override, as in fs:[rax] or [gs:0x30].
+func (p *Parser) parseSegmentOverride(seg lexer.Token) string {
+	p.expect(lexer.TOK_COLON)
+	return strings.ToLower(seg.Lit)
+}
+
+func (p *Parser) overrideSegment(mem *ast.MemOperand, seg string) {
+	if mem.Seg != "" {
+		p.Errors = append(p.Errors, fmt.Sprintf("invalid effective address: more than one segment override at line %d", mem.Line))
+	}
+	mem.Seg = seg
+}
+
+// parseStackRegister reads the x87 register after st: st(i) names st0-st7
+// and st alone is the top of the stack.
+func (p *Parser) parseStackRegister(st lexer.Token) string {
+	t := p.next()
+	if t.Kind != lexer.TOK_LPAREN {
+		p.backup(t)
+		return "st0"
+	}
+	n := p.expect(lexer.TOK_NUMBER)
+	p.expect(lexer.TOK_RPAREN)
+	i, err := parseNumber(n.Lit)
+	if err != nil || i < 0 || i > 7 {
+		p.Errors = append(p.Errors, fmt.Sprintf("invalid x87 register st(%s) at line %d", n.Lit, st.Line))
+	}
+	return fmt.Sprintf("st%d", i)
+}
+
+// parseDecorators reads AVX-512 operand decorators such as {k1}, {z} and
+// {1to16}, returning their contents in lower case.
+func (p *Parser) parseDecorators() []string {
+	var decs []string
 	for {
 		t := p.next()
-		if t.Kind == lexer.TOK_EOF {
-			break
-		}
-		if t.Kind == lexer.TOK_LBRACK {
-			depth++
-			sb.WriteString(t.Lit)
-			continue
+		if t.Kind != lexer.TOK_LBRACE {
+			p.backup(t)
+			return decs
 		}
-		if t.Kind == lexer.TOK_RBRACK {
-			depth--
-			if depth == 0 {
+		var sb strings.Builder
+		for {
+			t = p.next()
+			if t.Kind == lexer.TOK_RBRACE {
 				break
 			}
+			if t.Kind == lexer.TOK_NEWLINE || t.Kind == lexer.TOK_EOF {
+				p.Errors = append(p.Errors, fmt.Sprintf("expected } at line %d", t.Line))
+				p.backup(t)
+				return decs
+			}
 			sb.WriteString(t.Lit)
+		}
+		decs = append(decs, strings.ToLower(sb.String()))
+	}
+}
+
+func (p *Parser) decorateReg(reg *ast.RegOperand, decs []string, line int) {
+	for _, d := range decs {
+		switch {
+		case d == "z":
+			reg.Zero = true
+		case isMaskRegister(d) && reg.Mask == "":
+			reg.Mask = d
+		default:
+			p.Errors = append(p.Errors, fmt.Sprintf("invalid decorator {%s} on %s at line %d", d, reg.Name, line))
+		}
+	}
+}
+
+func (p *Parser) decorateMem(mem *ast.MemOperand, decs []string) {
+	for _, d := range decs {
+		if n, ok := strings.CutPrefix(d, "1to"); ok && mem.Bcst == 0 {
+			switch n {
+			case "2", "4", "8", "16":
+				mem.Bcst, _ = strconv.Atoi(n)
+				continue
+			}
+		}
+		if isMaskRegister(d) && mem.Mask == "" {
+			mem.Mask = d
 			continue
 		}
-		if t.Kind == lexer.TOK_STRING {
-			sb.WriteString("\"")
-			sb.WriteString(t.Lit)
-			sb.WriteString("\"")
+		p.Errors = append(p.Errors, fmt.Sprintf("invalid decorator {%s} on memory operand at line %d", d, mem.Line))
+	}
+}
+
+// parseRounding reads a standalone {rn-sae}, {rd-sae}, {ru-sae}, {rz-sae}
+// or {sae} operand.
+func (p *Parser) parseRounding(line int) (ast.Operand, bool) {
+	decs := p.parseDecorators()
+	if len(decs) == 1 {
+		switch decs[0] {
+		case "rn-sae", "rd-sae", "ru-sae", "rz-sae", "sae":
+			return ast.RoundingOperand{Mode: decs[0]}, true
+		}
+	}
+	p.Errors = append(p.Errors, fmt.Sprintf("invalid rounding operand {%s} at line %d", strings.Join(decs, "}{"), line))
+	return nil, false
+}
+
+func isMaskRegister(s string) bool {
+	return len(s) == 2 && s[0] == 'k' && s[1] >= '1' && s[1] <= '7'
+}
+
+type addrReg struct {
+	name   string
+	scale  int
+	scaled bool
+}
+
+func (p *Parser) parseMemOperand(lbrack lexer.Token) ast.MemOperand {
+	mem := ast.MemOperand{Line: lbrack.Line, Col: lbrack.Col}
+	var regs []addrReg
+	op := "+"
+
+	for prefix := true; prefix; {
+		t := p.next()
+		switch {
+		case t.Kind == lexer.TOK_IDENT && strings.ToLower(t.Lit) == "rel":
+			mem.Rel = true
+		case t.Kind == lexer.TOK_IDENT && strings.ToLower(t.Lit) == "abs":
+			mem.Abs = true
+		case t.Kind == lexer.TOK_IDENT && isSegmentRegister(t.Lit):
+			p.overrideSegment(&mem, p.parseSegmentOverride(t))
+		default:
+			p.backup(t)
+			prefix = false
+		}
+	}
+
+	for {
+		term := p.parseExprLevel2()
+		if reg, ok := p.addrRegister(term, lbrack.Line); ok {
+			if op == "-" {
+				p.Errors = append(p.Errors, fmt.Sprintf("invalid effective address: cannot subtract register %s at line %d", reg.name, lbrack.Line))
+			}
+			regs = append(regs, reg)
+		} else if containsRegister(term) {
+			p.Errors = append(p.Errors, fmt.Sprintf("invalid effective address: unsupported register expression at line %d", lbrack.Line))
+		} else if mem.Disp == nil {
+			if op == "-" {
+				term = ast.UnaryExpr{Op: "-", X: term}
+			}
+			mem.Disp = term
+		} else {
+			mem.Disp = ast.BinaryExpr{Op: op, Left: mem.Disp, Right: term}
+		}
+
+		t := p.next()
+		if t.Kind == lexer.TOK_PLUS || t.Kind == lexer.TOK_MINUS {
+			op = t.Lit
 			continue
 		}
-		sb.WriteString(t.Lit)
+		if t.Kind != lexer.TOK_RBRACK {
+			p.Errors = append(p.Errors, fmt.Sprintf("expected ] but got %s (%s) at line %d", t.Kind, t.Lit, t.Line))
+			p.backup(t)
+		}
+		break
+	}
+
+	p.assignAddrRegs(&mem, regs)
+	if mem.Rel && (mem.Base != "" || mem.Index != "") {
+		p.Errors = append(p.Errors, fmt.Sprintf("invalid effective address: rel cannot be combined with registers at line %d", mem.Line))
+	}
+	if strings.EqualFold(mem.Base, "rip") {
+		if mem.Index != "" || mem.Abs {
+			p.Errors = append(p.Errors, fmt.Sprintf("invalid effective address: rip cannot be combined with an index or abs at line %d", mem.Line))
+		}
+		mem.Base = ""
+		mem.Rel = true
+	}
+	return mem
+}
 
-		sb.WriteString(" ")
+func (p *Parser) addrRegister(term ast.Expr, line int) (addrReg, bool) {
+	switch v := term.(type) {
+	case ast.IdentExpr:
+		if isRegister(v.Name) {
+			return addrReg{name: v.Name, scale: 1}, true
+		}
+	case ast.BinaryExpr:
+		if v.Op != "*" {
+			return addrReg{}, false
+		}
+		reg, regOK := v.Left.(ast.IdentExpr)
+		num, numOK := v.Right.(ast.NumberExpr)
+		if !regOK || !numOK {
+			reg, regOK = v.Right.(ast.IdentExpr)
+			num, numOK = v.Left.(ast.NumberExpr)
+		}
+		if !regOK || !numOK || !isRegister(reg.Name) {
+			return addrReg{}, false
+		}
+		switch num.Val {
+		case 1, 2, 4, 8:
+		default:
+			p.Errors = append(p.Errors, fmt.Sprintf("invalid effective address: scale %d must be 1, 2, 4 or 8 at line %d", num.Val, line))
+		}
+		return addrReg{name: reg.Name, scale: int(num.Val), scaled: true}, true
 	}
-	return sb.String()
+	return addrReg{}, false
+}
+
+func (p *Parser) assignAddrRegs(mem *ast.MemOperand, regs []addrReg) {
+	var scaled, plain []addrReg
+	for _, r := range regs {
+		if r.scaled {
+			scaled = append(scaled, r)
+		} else {
+			plain = append(plain, r)
+		}
+	}
+
+	switch {
+	case len(scaled) > 1:
+		p.Errors = append(p.Errors, fmt.Sprintf("invalid effective address: more than one scaled index register at line %d", mem.Line))
+		return
+	case len(regs) > 2:
+		p.Errors = append(p.Errors, fmt.Sprintf("invalid effective address: too many registers at line %d", mem.Line))
+		return
+	}
+
+	if len(scaled) == 1 {
+		mem.Index = scaled[0].name
+		mem.Scale = scaled[0].scale
+		if len(plain) == 1 {
+			mem.Base = plain[0].name
+		}
+		return
+	}
+
+	if len(plain) > 0 {
+		mem.Base = plain[0].name
+	}
+	if len(plain) > 1 {
+		mem.Index = plain[1].name
+		mem.Scale = 1
+		if isStackPointer(mem.Index) {
+			mem.Base, mem.Index = mem.Index, mem.Base
+		}
+	}
+}
+
+func containsRegister(e ast.Expr) bool {
+	switch v := e.(type) {
+	case ast.IdentExpr:
+		return isRegister(v.Name)
+	case ast.BinaryExpr:
+		return containsRegister(v.Left) || containsRegister(v.Right)
+	case ast.UnaryExpr:
+		return containsRegister(v.X)
+	}
+	return false
+}
+
+func isStackPointer(s string) bool {
+	switch strings.ToLower(s) {
+	case "rsp", "esp", "sp":
+		return true
+	}
+	return false
+}
+
+func isPrefix(s string) bool {
+	switch strings.ToLower(s) {
+	case "lock", "rep", "repe", "repz", "repne", "repnz":
+		return true
+	}
+	return false
 }
 
 func isRegister(s string) bool {
@@ -321,28 +694,47 @@ func isRegister(s string) bool {
 		"bl", "bh", "bx", "ebx", "rbx",
 		"cl", "ch", "cx", "ecx", "rcx",
 		"dl", "dh", "dx", "edx", "rdx",
-		"si", "esi", "rsi", "di", "edi", "rdi",
-		"sp", "esp", "rsp", "bp", "ebp", "rbp",
+		"sil", "si", "esi", "rsi", "dil", "di", "edi", "rdi",
+		"spl", "sp", "esp", "rsp", "bpl", "bp", "ebp", "rbp",
 		"r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15",
 		"r8d", "r9d", "r10d", "r11d", "r12d", "r13d", "r14d", "r15d",
 		"r8w", "r9w", "r10w", "r11w", "r12w", "r13w", "r14w", "r15w",
 		"r8b", "r9b", "r10b", "r11b", "r12b", "r13b", "r14b", "r15b",
 		"mm0", "mm1", "mm2", "mm3", "mm4", "mm5", "mm6", "mm7",
 		"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5", "xmm6", "xmm7", "xmm8", "xmm9", "xmm10", "xmm11", "xmm12", "xmm13", "xmm14", "xmm15",
-		"cr0", "cr2", "cr3", "cr4",
-		"dr0", "dr1", "dr2", "dr3", "dr6", "dr7",
+		"ymm0", "ymm1", "ymm2", "ymm3", "ymm4", "ymm5", "ymm6", "ymm7", "ymm8", "ymm9", "ymm10", "ymm11", "ymm12", "ymm13", "ymm14", "ymm15",
+		"xmm16", "xmm17", "xmm18", "xmm19", "xmm20", "xmm21", "xmm22", "xmm23", "xmm24", "xmm25", "xmm26", "xmm27", "xmm28", "xmm29", "xmm30", "xmm31",
+		"ymm16", "ymm17", "ymm18", "ymm19", "ymm20", "ymm21", "ymm22", "ymm23", "ymm24", "ymm25", "ymm26", "ymm27", "ymm28", "ymm29", "ymm30", "ymm31",
+		"zmm0", "zmm1", "zmm2", "zmm3", "zmm4", "zmm5", "zmm6", "zmm7", "zmm8", "zmm9", "zmm10", "zmm11", "zmm12", "zmm13", "zmm14", "zmm15",
+		"zmm16", "zmm17", "zmm18", "zmm19", "zmm20", "zmm21", "zmm22", "zmm23", "zmm24", "zmm25", "zmm26", "zmm27", "zmm28", "zmm29", "zmm30", "zmm31",
+		"k0", "k1", "k2", "k3", "k4", "k5", "k6", "k7",
+		"st0", "st1", "st2", "st3", "st4", "st5", "st6", "st7",
+		"cr0", "cr2", "cr3", "cr4", "cr8",
+		"dr0", "dr1", "dr2", "dr3", "dr4", "dr5", "dr6", "dr7",
+		"cs", "ds", "es", "fs", "gs", "ss",
 		"rip", "eip", "ip", "flags", "rflags", "eflags":
 		return true
 	}
 	return false
 }
 
+func isSegmentRegister(s string) bool {
+	switch strings.ToLower(s) {
+	case "cs", "ds", "es", "fs", "gs", "ss":
+		return true
+	}
+	return false
+}
+
 func (p *Parser) parseExpr() ast.Expr {
 	return p.parseExprLevel1()
 }
 
 func (p *Parser) parseExprLevel1() ast.Expr {
-	left := p.parseExprLevel2()
+	return p.continueExprLevel1(p.parseExprLevel2())
+}
+
+func (p *Parser) continueExprLevel1(left ast.Expr) ast.Expr {
 	for {
 		t := p.next()
 		if t.Kind == lexer.TOK_PLUS || t.Kind == lexer.TOK_MINUS {
@@ -357,7 +749,10 @@ func (p *Parser) parseExprLevel1() ast.Expr {
 }
 
 func (p *Parser) parseExprLevel2() ast.Expr {
-	left := p.parseExprFactor()
+	return p.continueExprLevel2(p.parseExprFactor())
+}
+
+func (p *Parser) continueExprLevel2(left ast.Expr) ast.Expr {
 	for {
 		t := p.next()
 		if t.Kind == lexer.TOK_STAR || t.Kind == lexer.TOK_SLASH {
@@ -374,6 +769,9 @@ func (p *Parser) parseExprLevel2() ast.Expr {
 func (p *Parser) parseExprFactor() ast.Expr {
 	t := p.next()
 	if t.Kind == lexer.TOK_NUMBER {
+		if isFloatLiteral(t.Lit) {
+			return ast.FloatExpr{Lit: t.Lit}
+		}
 		v, _ := parseNumber(t.Lit)
 		return ast.NumberExpr{Val: v}
 	}
@@ -401,6 +799,18 @@ func (p *Parser) parseExprFactor() ast.Expr {
 	return ast.IdentExpr{Name: t.Lit}
 }
 
+func isFloatLiteral(s string) bool {
+	return strings.Contains(s, ".") && !strings.HasPrefix(strings.ToLower(s), "0x")
+}
+
+func isDataDirective(s string) bool {
+	switch strings.ToLower(s) {
+	case "db", "dw", "dd", "dq", "dt", "resb", "resw", "resd":
+		return true
+	}
+	return false
+}
+
 func parseNumber(s string) (int64, error) {
 	s = strings.TrimSpace(s)
 	if s == "" {
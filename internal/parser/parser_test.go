@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"gasm/internal/ast"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var operandTests = []struct {
+	src  string
+	want []ast.Operand
+}{
+	// Effective addresses are split into base, index, scale and displacement.
+	{"mov rax, [rbx+rcx*4+8]", []ast.Operand{
+		ast.RegOperand{Name: "rax"},
+		ast.MemOperand{Base: "rbx", Index: "rcx", Scale: 4, Disp: ast.NumberExpr{Val: 8}},
+	}},
+	{"mov rax, [rcx*2]", []ast.Operand{
+		ast.RegOperand{Name: "rax"},
+		ast.MemOperand{Index: "rcx", Scale: 2},
+	}},
+	{"mov [rsp-0x10], rax", []ast.Operand{
+		ast.MemOperand{Base: "rsp", Disp: ast.UnaryExpr{Op: "-", X: ast.NumberExpr{Val: 16}}},
+		ast.RegOperand{Name: "rax"},
+	}},
+	{"mov rax, [msg+8]", []ast.Operand{
+		ast.RegOperand{Name: "rax"},
+		ast.MemOperand{Disp: ast.BinaryExpr{Op: "+", Left: ast.IdentExpr{Name: "msg"}, Right: ast.NumberExpr{Val: 8}}},
+	}},
+
+	// A size keyword sizes the memory operand that follows it.
+	{"mov dword [rax], 1", []ast.Operand{
+		ast.MemOperand{Base: "rax", Size: 4},
+		ast.ImmOperand{Val: ast.NumberExpr{Val: 1}},
+	}},
+	{"inc qword [rbx+8]", []ast.Operand{ast.MemOperand{Base: "rbx", Disp: ast.NumberExpr{Val: 8}, Size: 8}}},
+
+	// rel makes an address rip-relative.
+	{"lea rax, [rel msg]", []ast.Operand{
+		ast.RegOperand{Name: "rax"},
+		ast.MemOperand{Disp: ast.IdentExpr{Name: "msg"}, Rel: true},
+	}},
+
+	// abs overrides default rel for one operand.
+	{"mov eax, [abs 0x10]", []ast.Operand{
+		ast.RegOperand{Name: "eax"},
+		ast.MemOperand{Disp: ast.NumberExpr{Val: 16}, Abs: true},
+	}},
+
+	// EVEX decorators.
+	{"vaddps zmm1{k1}{z}, zmm2, [rax]{1to16}", []ast.Operand{
+		ast.RegOperand{Name: "zmm1", Mask: "k1", Zero: true},
+		ast.RegOperand{Name: "zmm2"},
+		ast.MemOperand{Base: "rax", Bcst: 16},
+	}},
+	{"vaddps zmm1, zmm2, zmm3, {rn-sae}", []ast.Operand{
+		ast.RegOperand{Name: "zmm1"},
+		ast.RegOperand{Name: "zmm2"},
+		ast.RegOperand{Name: "zmm3"},
+		ast.RoundingOperand{Mode: "rn-sae"},
+	}},
+
+	// st(i) names an x87 stack register.
+	{"fadd st0, st(3)", []ast.Operand{
+		ast.RegOperand{Name: "st0"},
+		ast.RegOperand{Name: "st3"},
+	}},
+
+	// A segment override inside the brackets.
+	{"mov eax, [fs:rax+8]", []ast.Operand{
+		ast.RegOperand{Name: "eax"},
+		ast.MemOperand{Base: "rax", Disp: ast.NumberExpr{Val: 8}, Seg: "fs"},
+	}},
+
+	// Far pointers.
+	{"jmp 0x10:0x20", []ast.Operand{ast.FarOperand{Seg: ast.NumberExpr{Val: 16}, Off: ast.NumberExpr{Val: 32}}}},
+
+	// A size keyword before an immediate gives the width of its field.
+	{"add eax, byte 5", []ast.Operand{
+		ast.RegOperand{Name: "eax"},
+		ast.ImmOperand{Val: ast.NumberExpr{Val: 5}, Size: 1},
+	}},
+	{"jmp dword 0x10:0x20", []ast.Operand{ast.FarOperand{Seg: ast.NumberExpr{Val: 16}, Off: ast.NumberExpr{Val: 32}, Size: 4}}},
+}
+
+func TestParseOperands(t *testing.T) {
+	for _, tt := range operandTests {
+		f := parse(t, tt.src)
+		ins, ok := f.Items[0].(*ast.Instruction)
+		if !ok {
+			t.Errorf("%s: got %T, want an instruction", tt.src, f.Items[0])
+			continue
+		}
+		for i, op := range ins.Operands {
+			if m, ok := op.(ast.MemOperand); ok {
+				m.Line, m.Col = 0, 0
+				ins.Operands[i] = m
+			}
+		}
+		if !reflect.DeepEqual(ins.Operands, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.src, ins.Operands, tt.want)
+		}
+	}
+}
+
+func TestParseLabelWithoutColon(t *testing.T) {
+	f := parse(t, "msg db 1")
+	if len(f.Items) != 2 {
+		t.Fatalf("got %d items, want 2", len(f.Items))
+	}
+	if l, ok := f.Items[0].(*ast.Label); !ok || l.Name != "msg" {
+		t.Errorf("got %#v, want label msg", f.Items[0])
+	}
+	if d, ok := f.Items[1].(*ast.DataDecl); !ok || d.Kind != "db" {
+		t.Errorf("got %#v, want a db declaration", f.Items[1])
+	}
+}
+
+func TestParseFloatData(t *testing.T) {
+	f := parse(t, "dt 1.5, -2.0e3, 1.0e-45")
+	d, ok := f.Items[0].(*ast.DataDecl)
+	if !ok {
+		t.Fatalf("got %#v, want a dt declaration", f.Items[0])
+	}
+	want := []ast.ExprOrString{
+		{Expr: ast.FloatExpr{Lit: "1.5"}},
+		{Expr: ast.UnaryExpr{Op: "-", X: ast.FloatExpr{Lit: "2.0e3"}}},
+		{Expr: ast.FloatExpr{Lit: "1.0e-45"}},
+	}
+	if !reflect.DeepEqual(d.Items, want) {
+		t.Errorf("got %#v, want %#v", d.Items, want)
+	}
+}
+
+func TestParseBracketedDirective(t *testing.T) {
+	f := parse(t, "[bits 16]")
+	d, ok := f.Items[0].(*ast.Directive)
+	if !ok || d.Name != "bits" || !reflect.DeepEqual(d.Args, []string{"16"}) {
+		t.Errorf("got %#v, want bits 16", f.Items[0])
+	}
+}
+
+func parse(t *testing.T, src string) *ast.File {
+	t.Helper()
+	p := New(strings.NewReader(src + "\n"))
+	f := p.ParseFile()
+	if len(p.Errors) > 0 || len(f.Items) == 0 {
+		t.Fatalf("%s: parse errors %v", src, p.Errors)
+	}
+	return f
+}